@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"goji.io"
+	"golang.org/x/net/context"
+)
+
+// Formats of the access log.
+const (
+	// AccessLogCombined writes lines in the Apache combined log format followed by the duration in microseconds.
+	AccessLogCombined = "combined"
+	// AccessLogJSON writes a structured log record like all other logs.
+	AccessLogJSON = "json"
+)
+
+// AccessLog returns a middleware that logs one line per request after it was
+// handled with method, path, status, response size, duration and client IP.
+// Combined lines are written to out, JSON records to the request logger.
+func AccessLog(format string, out io.Writer) (func(goji.Handler) goji.Handler, error) {
+	switch format {
+	case AccessLogCombined, AccessLogJSON:
+	default:
+		return nil, fmt.Errorf("unknown access log format '%s'", format)
+	}
+
+	return func(h goji.Handler) goji.Handler {
+		return goji.HandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			sw := NewStatusWriter(w)
+
+			h.ServeHTTPC(ctx, sw, r)
+
+			duration := time.Since(start)
+
+			if format == AccessLogJSON {
+				Logger(ctx).LogAttrs(ctx, slog.LevelInfo, "access",
+					slog.String("client_ip", ClientIP(r)),
+					slog.Int("status", sw.status),
+					slog.Int("bytes", sw.bytes),
+					slog.Duration("duration", duration),
+				)
+				return
+			}
+
+			user, _, ok := r.BasicAuth()
+			if !ok || user == "" {
+				user = "-"
+			}
+			size := "-"
+			if sw.bytes > 0 {
+				size = strconv.Itoa(sw.bytes)
+			}
+
+			fmt.Fprintf(out, "%s - %s [%s] %q %d %s %q %q %d\n",
+				ClientIP(r),
+				user,
+				start.Format("02/Jan/2006:15:04:05 -0700"),
+				r.Method+" "+r.URL.RequestURI()+" "+r.Proto,
+				sw.status,
+				size,
+				r.Referer(),
+				r.UserAgent(),
+				duration.Microseconds(),
+			)
+		})
+	}, nil
+}
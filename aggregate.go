@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/HouzuoGuo/tiedot/db"
+	"goji.io/pat"
+	"golang.org/x/net/context"
+)
+
+// ForEachDocument decodes every document of the collection and calls fun with it
+// until fun returns false. Documents that cannot be decoded are skipped.
+func ForEachDocument(coll *db.Col, fun func(id int, doc map[string]interface{}) bool) {
+	coll.ForEachDoc(func(id int, raw []byte) bool {
+		doc := map[string]interface{}{}
+		if err := json.Unmarshal(raw, &doc); err != nil {
+			return true
+		}
+		return fun(id, doc)
+	})
+}
+
+// DistinctValuesHandler handles: GET /db/:collection/distinct?field=status.
+// Returns the sorted unique values of the top-level field across the collection.
+// Documents without the field are skipped. The optional 'limit' param caps
+// the number of returned values.
+func (d *DBController) DistinctValuesHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	collName := pat.Param(ctx, "collection")
+
+	coll := d.UseCollection(ctx, w)
+	if coll == nil {
+		return
+	}
+
+	field := r.URL.Query().Get("field")
+	if field == "" {
+		WriteError(ctx, w, http.StatusBadRequest, CodeBadRequest, "field must be given")
+		return
+	}
+
+	limit := 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 0 {
+			WriteError(ctx, w, http.StatusBadRequest, CodeBadRequest, "limit must be a non-negative number")
+			return
+		}
+	}
+
+	hideDeleted := d.hideDeleted(collName, r)
+
+	// Values are keyed by their JSON encoding so 1 and "1" stay distinct.
+	seen := map[string]bool{}
+	values := []interface{}{}
+
+	ForEachDocument(coll, func(id int, doc map[string]interface{}) bool {
+		if hideDeleted && IsDeleted(doc) {
+			return true
+		}
+
+		v, ok := doc[field]
+		if !ok {
+			return true
+		}
+
+		key, err := json.Marshal(v)
+		if err != nil || seen[string(key)] {
+			return true
+		}
+		seen[string(key)] = true
+		values = append(values, v)
+		return true
+	})
+
+	SortValues(values)
+
+	total := len(values)
+	if limit > 0 && limit < len(values) {
+		values = values[:limit]
+	}
+
+	WriteResponse(ctx, w, http.StatusOK, map[string]interface{}{
+		"field":  field,
+		"values": values,
+		"total":  total,
+	})
+}
+
+// ForEachMatchingDocument calls fun with every document of the collection that
+// matches the Tiedot query until fun returns false. A nil query matches all documents.
+// Soft-deleted documents are skipped with hideDeleted.
+func ForEachMatchingDocument(coll *db.Col, query interface{}, hideDeleted bool, fun func(id int, doc map[string]interface{}) bool) error {
+	visit := func(id int, doc map[string]interface{}) bool {
+		if hideDeleted && IsDeleted(doc) {
+			return true
+		}
+		return fun(id, doc)
+	}
+
+	if query == nil {
+		ForEachDocument(coll, visit)
+		return nil
+	}
+
+	ids, err := QueryIDs(coll, query)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		doc, err := coll.Read(id)
+		if err != nil || doc == nil {
+			continue
+		}
+		if !visit(id, doc) {
+			break
+		}
+	}
+	return nil
+}
+
+// numericValue coerces a decoded JSON value to float64.
+// Numbers and strings containing a number are numeric.
+func numericValue(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil && !math.IsNaN(f) && !math.IsInf(f, 0)
+	}
+	return 0, false
+}
+
+// AggregateHandler handles: POST /db/:collection/aggregate.
+// Computes 'sum', 'avg', 'min', 'max' or 'count' of the numeric values of the
+// field, which may address nested fields with dots. Documents where the field
+// is missing or not numeric are skipped. The optional Tiedot 'query' restricts
+// the aggregated documents. avg, min and max are null if no value was found.
+// Payload example:
+//
+//	{
+//	  "field": "amount",
+//	  "op": "sum",
+//	  "query": {"eq": "paid", "in": ["status"]}
+//	}
+func (d *DBController) AggregateHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	collName := pat.Param(ctx, "collection")
+
+	coll := d.UseCollection(ctx, w)
+	if coll == nil {
+		return
+	}
+
+	// Parse JSON object from POST parameter.
+	d.LimitBody(w, r)
+	js, err := ParsePostJSON(r)
+	if err != nil {
+		WriteBodyError(ctx, w, err)
+		return
+	}
+
+	field, ok := js["field"].(string)
+	if !ok || field == "" {
+		WriteError(ctx, w, http.StatusBadRequest, CodeBadRequest, "field must be a non-empty string")
+		return
+	}
+
+	op, _ := js["op"].(string)
+	switch op {
+	case "sum", "avg", "min", "max", "count":
+	default:
+		WriteError(ctx, w, http.StatusBadRequest, CodeBadRequest, "op must be one of sum, avg, min, max or count")
+		return
+	}
+
+	path := strings.Split(field, ".")
+	count := 0
+	sum, minValue, maxValue := 0.0, math.Inf(1), math.Inf(-1)
+
+	err = ForEachMatchingDocument(coll, js["query"], d.hideDeleted(collName, r), func(id int, doc map[string]interface{}) bool {
+		v, ok := pathValue(doc, path)
+		if !ok {
+			return true
+		}
+		f, ok := numericValue(v)
+		if !ok {
+			return true
+		}
+
+		count++
+		sum += f
+		minValue = math.Min(minValue, f)
+		maxValue = math.Max(maxValue, f)
+		return true
+	})
+	if err != nil {
+		WriteError(ctx, w, http.StatusBadRequest, CodeInvalidQuery, err.Error())
+		return
+	}
+
+	var value interface{}
+	switch op {
+	case "sum":
+		value = sum
+	case "count":
+		value = count
+	case "avg":
+		if count > 0 {
+			value = sum / float64(count)
+		}
+	case "min":
+		if count > 0 {
+			value = minValue
+		}
+	case "max":
+		if count > 0 {
+			value = maxValue
+		}
+	}
+
+	WriteResponse(ctx, w, http.StatusOK, map[string]interface{}{
+		"field": field,
+		"op":    op,
+		"value": value,
+		"count": count,
+	})
+}
+
+// GroupByHandler handles: POST /db/:collection/groupby.
+// Counts the documents per value of the top-level field. Values are used as
+// they are for strings and JSON encoded for all other types, so 1 and "1"
+// share a group. Documents without the field are skipped.
+// The optional Tiedot 'query' restricts the counted documents.
+// Payload example:
+//
+//	{
+//	  "field": "status",
+//	  "query": {"eq": "Goethe", "in": ["author"]}
+//	}
+func (d *DBController) GroupByHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	collName := pat.Param(ctx, "collection")
+
+	coll := d.UseCollection(ctx, w)
+	if coll == nil {
+		return
+	}
+
+	// Parse JSON object from POST parameter.
+	d.LimitBody(w, r)
+	js, err := ParsePostJSON(r)
+	if err != nil {
+		WriteBodyError(ctx, w, err)
+		return
+	}
+
+	field, ok := js["field"].(string)
+	if !ok || field == "" {
+		WriteError(ctx, w, http.StatusBadRequest, CodeBadRequest, "field must be a non-empty string")
+		return
+	}
+
+	groups := map[string]int{}
+	total := 0
+
+	err = ForEachMatchingDocument(coll, js["query"], d.hideDeleted(collName, r), func(id int, doc map[string]interface{}) bool {
+		v, ok := doc[field]
+		if !ok {
+			return true
+		}
+
+		key, ok := v.(string)
+		if !ok {
+			encoded, err := json.Marshal(v)
+			if err != nil {
+				return true
+			}
+			key = string(encoded)
+		}
+
+		groups[key]++
+		total++
+		return true
+	})
+	if err != nil {
+		WriteError(ctx, w, http.StatusBadRequest, CodeInvalidQuery, err.Error())
+		return
+	}
+
+	WriteResponse(ctx, w, http.StatusOK, map[string]interface{}{
+		"field":  field,
+		"groups": groups,
+		"total":  total,
+	})
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"goji.io"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/net/context"
+)
+
+// AuthExempt lists the paths that never require authentication.
+var AuthExempt = map[string]bool{
+	"/health":  true,
+	"/ready":   true,
+	"/version": true,
+}
+
+// BasicAuth checks HTTP Basic credentials against the users of a htpasswd file.
+type BasicAuth struct {
+	// users maps user names to password hashes.
+	users map[string]string
+}
+
+// LoadHtpasswd reads the users from a htpasswd file with one 'user:hash' per line.
+// Supported hashes are bcrypt ($2y$, created with 'htpasswd -B') and SHA1 ({SHA}, 'htpasswd -s').
+// Empty lines and lines starting with '#' are ignored.
+func LoadHtpasswd(path string) (*BasicAuth, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	a := &BasicAuth{users: map[string]string{}}
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		user, hash, ok := strings.Cut(text, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("line %d: expected user:hash", line)
+		}
+		if !strings.HasPrefix(hash, "$2") && !strings.HasPrefix(hash, "{SHA}") {
+			return nil, fmt.Errorf("line %d: unsupported hash for user '%s', use bcrypt or SHA1", line, user)
+		}
+		a.users[user] = hash
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Check reports whether the password is valid for the user.
+// Hashes are compared in constant time.
+func (a *BasicAuth) Check(user, password string) bool {
+	hash, ok := a.users[user]
+	if !ok {
+		return false
+	}
+
+	if sha, ok := strings.CutPrefix(hash, "{SHA}"); ok {
+		sum := sha1.Sum([]byte(password))
+		expected := base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(sha), []byte(expected)) == 1
+	}
+
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// Middleware rejects requests without valid Basic credentials with 401.
+// Paths in AuthExempt are always allowed.
+func (a *BasicAuth) Middleware(h goji.Handler) goji.Handler {
+	return goji.HandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		if AuthExempt[r.URL.Path] {
+			h.ServeHTTPC(ctx, w, r)
+			return
+		}
+
+		user, password, ok := r.BasicAuth()
+		if !ok || !a.Check(user, password) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="crudmachine", charset="UTF-8"`)
+			WriteError(ctx, w, http.StatusUnauthorized, CodeUnauthorized, "valid credentials are required")
+			return
+		}
+
+		h.ServeHTTPC(ctx, w, r)
+	})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"goji.io"
+	"goji.io/pat"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/net/context"
+)
+
+func TestBasicAuthMiddleware(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha1.Sum([]byte("hunter2"))
+	htpasswd := "# users\n\nalice:" + string(bcryptHash) + "\nbob:{SHA}" + base64.StdEncoding.EncodeToString(sum[:]) + "\n"
+
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	if err := os.WriteFile(path, []byte(htpasswd), 0600); err != nil {
+		t.Fatal(err)
+	}
+	auth, err := LoadHtpasswd(path)
+	if err != nil {
+		t.Fatalf("LoadHtpasswd() error = %v", err)
+	}
+
+	mux := goji.NewMux()
+	mux.UseC(auth.Middleware)
+	ok := func(ctx context.Context, w http.ResponseWriter, r *http.Request) {}
+	mux.HandleFuncC(pat.Get("/db/books"), ok)
+	mux.HandleFuncC(pat.Get("/health"), ok)
+
+	tests := []struct {
+		name     string
+		path     string
+		user     string
+		password string
+		want     int
+	}{
+		{name: "no credentials", path: "/db/books", want: http.StatusUnauthorized},
+		{name: "bcrypt", path: "/db/books", user: "alice", password: "secret", want: http.StatusOK},
+		{name: "sha1", path: "/db/books", user: "bob", password: "hunter2", want: http.StatusOK},
+		{name: "wrong password", path: "/db/books", user: "alice", password: "hunter2", want: http.StatusUnauthorized},
+		{name: "unknown user", path: "/db/books", user: "carol", password: "secret", want: http.StatusUnauthorized},
+		{name: "exempt", path: "/health", want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.path, nil)
+			if tt.user != "" {
+				r.SetBasicAuth(tt.user, tt.password)
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+			if challenge := w.Header().Get("WWW-Authenticate"); (w.Code == http.StatusUnauthorized) != (challenge != "") {
+				t.Errorf("WWW-Authenticate = %q with status %d", challenge, w.Code)
+			}
+		})
+	}
+}
+
+func TestLoadHtpasswdUnsupportedHash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	if err := os.WriteFile(path, []byte("alice:$apr1$salt$hash\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadHtpasswd(path); err == nil {
+		t.Error("LoadHtpasswd() error = nil, want error for MD5 hashes")
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ProbePaths are served at the root even if a base path is configured, so
+// health checks keep working without knowing the prefix of the gateway.
+var ProbePaths = map[string]bool{
+	"/health": true,
+	"/ready":  true,
+}
+
+// CleanBasePath validates the base path all routes are served under and
+// removes a trailing slash. An empty or "/" base path serves routes at the root.
+func CleanBasePath(basePath string) (string, error) {
+	basePath = strings.TrimRight(basePath, "/")
+	if basePath != "" && !strings.HasPrefix(basePath, "/") {
+		return "", fmt.Errorf("base path '%s' must start with /", basePath)
+	}
+	return basePath, nil
+}
+
+// StripBasePath wraps the router so all routes are served under basePath,
+// e.g. /api/v1/db/books for /db/books. Requests outside of it get 404 except
+// for ProbePaths. The base path must be cleaned with CleanBasePath.
+func StripBasePath(basePath string, h http.Handler) http.Handler {
+	if basePath == "" {
+		return h
+	}
+
+	strip := http.StripPrefix(basePath, h)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ProbePaths[r.URL.Path] {
+			h.ServeHTTP(w, r)
+			return
+		}
+		strip.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCleanBasePath(t *testing.T) {
+	tests := []struct {
+		basePath string
+		want     string
+		wantErr  bool
+	}{
+		{basePath: "", want: ""},
+		{basePath: "/", want: ""},
+		{basePath: "/api/v1", want: "/api/v1"},
+		{basePath: "/api/v1/", want: "/api/v1"},
+		{basePath: "api", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := CleanBasePath(tt.basePath)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("CleanBasePath(%q) = %q, %v, want %q, error %t", tt.basePath, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestStripBasePath(t *testing.T) {
+	d := newTestController(t)
+	d.BasePath = "/api/v1"
+	if err := d.DB.Create("books"); err != nil {
+		t.Fatal(err)
+	}
+	h := StripBasePath(d.BasePath, newTestMux(d))
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, newRequest(method, path, body))
+		return w
+	}
+
+	w := send("POST", "/api/v1/db/books", `{"title": "Dune"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST: status = %d, want %d", w.Code, http.StatusCreated)
+	}
+	location := w.Header().Get("Location")
+	if !strings.HasPrefix(location, "/api/v1/db/books/") {
+		t.Fatalf("Location = %q, want it under the base path", location)
+	}
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{path: location, want: http.StatusOK},
+		{path: strings.TrimPrefix(location, d.BasePath), want: http.StatusNotFound},
+		{path: "/api/v2/db/books", want: http.StatusNotFound},
+		{path: "/health", want: http.StatusOK},
+		{path: "/api/v1/health", want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		if w := send("GET", tt.path, ""); w.Code != tt.want {
+			t.Errorf("GET %s: status = %d, want %d", tt.path, w.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/HouzuoGuo/tiedot/db"
+	"goji.io/pat"
+	"golang.org/x/net/context"
+)
+
+// BulkCreateDocumentsHandler handles: POST /db/:collection/bulk.
+// Expects a JSON array of objects and inserts every object as a new document.
+// A failing document does not abort the batch. The response contains the
+// created ids in request order (null for failed items) and the per-item errors.
+// With ?dryRun=true the documents are only validated. No ids are assigned then
+// and 'inserted' counts the documents that would have been inserted.
+func (d *DBController) BulkCreateDocumentsHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	collName := pat.Param(ctx, "collection")
+
+	coll := d.UseCollection(ctx, w)
+	if coll == nil {
+		return
+	}
+
+	if !RequireJSON(ctx, w, r) {
+		return
+	}
+
+	// Parse JSON array from POST parameter.
+	d.LimitBody(w, r)
+	docs, err := ParsePostJSONArray(r)
+	if err != nil {
+		WriteBodyError(ctx, w, err)
+		return
+	}
+
+	d.insertDocuments(ctx, w, r, collName, coll, docs)
+}
+
+// insertDocuments inserts every object of docs into the collection and writes
+// the bulk response described at BulkCreateDocumentsHandler.
+// A nil coll is created with the first valid document.
+func (d *DBController) insertDocuments(ctx context.Context, w http.ResponseWriter, r *http.Request, collName string, coll *db.Col, docs []interface{}) {
+	ids := make([]interface{}, len(docs))
+	errs := []interface{}{}
+	inserted := 0
+	dryRun := IsDryRun(r)
+
+	for i, doc := range docs {
+		js, ok := doc.(map[string]interface{})
+		if !ok {
+			errs = append(errs, map[string]interface{}{
+				"index": i,
+				"error": NewError(CodeInvalidJSON, "item is not a json object"),
+			})
+			continue
+		}
+
+		d.ApplyDefaults(collName, js)
+		if violations := d.ValidateDocument(collName, js); len(violations) > 0 {
+			errs = append(errs, map[string]interface{}{
+				"index": i,
+				"error": SchemaViolationError(violations),
+			})
+			continue
+		}
+
+		if dryRun {
+			if err := d.CheckUnique(collName, coll, -1, js); err != nil {
+				errs = append(errs, map[string]interface{}{
+					"index": i,
+					"error": InsertError(err),
+				})
+				continue
+			}
+			inserted++
+			continue
+		}
+
+		if coll == nil {
+			var err error
+			if coll, err = d.createMissingCollection(ctx, collName); err != nil {
+				errs = append(errs, map[string]interface{}{
+					"index": i,
+					"error": InsertError(err),
+				})
+				continue
+			}
+		}
+
+		docID, _, err := d.InsertDocument(collName, coll, js)
+		if err != nil {
+			errs = append(errs, map[string]interface{}{
+				"index": i,
+				"error": InsertError(err),
+			})
+			continue
+		}
+
+		ids[i] = docID
+		inserted++
+	}
+
+	resp := map[string]interface{}{
+		"ids":      ids,
+		"inserted": inserted,
+		"failed":   len(errs),
+		"errors":   errs,
+	}
+
+	if dryRun {
+		resp["dryRun"] = true
+	} else {
+		Logger(ctx).Info("bulk inserted documents", "collection", collName, "inserted", inserted, "failed", len(errs))
+	}
+
+	WriteResponse(ctx, w, http.StatusOK, resp)
+}
+
+// BatchUpdateDocumentsHandler handles: POST /db/:collection/batch-update.
+// Patches multiple documents like PatchDocumentHandler does with a single one.
+// A failing item does not abort the batch. The response contains the updated
+// documents in request order (null for failed items) and the per-item errors.
+// With ?dryRun=true the patched documents are only validated.
+// Ids may be sent as numbers or strings.
+// Payload example:
+//
+//	[
+//	  {"id": 1, "patch": {"status": "done"}},
+//	  {"id": 2, "patch": {"status": "open"}}
+//	]
+func (d *DBController) BatchUpdateDocumentsHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	collName := pat.Param(ctx, "collection")
+
+	coll := d.UseCollection(ctx, w)
+	if coll == nil {
+		return
+	}
+
+	if !RequireJSON(ctx, w, r) {
+		return
+	}
+
+	// Parse JSON array from POST parameter. Items are kept raw to read their ids exactly.
+	d.LimitBody(w, r)
+	items := []json.RawMessage{}
+	if err := json.NewDecoder(r.Body).Decode(&items); err != nil {
+		WriteBodyError(ctx, w, err)
+		return
+	}
+
+	results := make([]interface{}, len(items))
+	errs := []interface{}{}
+	updated := 0
+	dryRun := IsDryRun(r)
+
+	for i, item := range items {
+		itemErr := func(err map[string]interface{}) {
+			errs = append(errs, map[string]interface{}{
+				"index": i,
+				"error": err,
+			})
+		}
+
+		var js map[string]json.RawMessage
+		if err := json.Unmarshal(item, &js); err != nil || js == nil {
+			itemErr(NewError(CodeInvalidJSON, "item is not a json object"))
+			continue
+		}
+
+		id, ok := ParseJSONID(js["id"])
+		if !ok {
+			itemErr(NewError(CodeBadID, "id must be a positive integer, as number or string"))
+			continue
+		}
+
+		var patch map[string]interface{}
+		if err := json.Unmarshal(js["patch"], &patch); err != nil || patch == nil {
+			itemErr(NewError(CodeBadRequest, "patch must be a json object"))
+			continue
+		}
+
+		doc, err := coll.Read(id)
+		if err != nil {
+			itemErr(NewError(CodeDocumentNotFound, "document "+strconv.Itoa(id)+" not found"))
+			continue
+		}
+
+		d.ApplyPatch(collName, id, doc, patch, false)
+
+		if violations := d.ValidateDocument(collName, doc); len(violations) > 0 {
+			itemErr(SchemaViolationError(violations))
+			continue
+		}
+
+		if dryRun {
+			err = d.CheckUnique(collName, coll, id, doc)
+		} else {
+			err = d.UpdateDocument(collName, coll, id, doc)
+		}
+		if err != nil {
+			itemErr(InsertError(err))
+			continue
+		}
+
+		results[i] = doc
+		updated++
+	}
+
+	resp := map[string]interface{}{
+		"results": results,
+		"updated": updated,
+		"failed":  len(errs),
+		"errors":  errs,
+	}
+
+	if dryRun {
+		resp["dryRun"] = true
+	} else {
+		Logger(ctx).Info("batch updated documents", "collection", collName, "updated", updated, "failed", len(errs))
+	}
+
+	WriteResponse(ctx, w, http.StatusOK, resp)
+}
+
+// DeleteByQueryHandler handles: POST /db/:collection/delete.
+// Deletes all documents matching the Tiedot query in the payload.
+// In collections with soft deletes the documents are only marked with deleted_at,
+// already deleted ones are not counted again. With ?hard=true they are removed permanently.
+// A failing deletion does not abort the others.
+// Payload example:
+//
+//	{
+//	  "query": {"eq": "expired", "in": ["status"]}
+//	}
+func (d *DBController) DeleteByQueryHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	collName := pat.Param(ctx, "collection")
+
+	coll := d.UseCollection(ctx, w)
+	if coll == nil {
+		return
+	}
+
+	// Parse JSON object from POST parameter.
+	d.LimitBody(w, r)
+	js, err := ParsePostJSON(r)
+	if err != nil {
+		WriteBodyError(ctx, w, err)
+		return
+	}
+
+	query, ok := js["query"]
+	if !ok {
+		WriteError(ctx, w, http.StatusBadRequest, CodeBadRequest, "request body does not contain a query")
+		return
+	}
+
+	ids, err := QueryIDs(coll, query)
+	if err != nil {
+		WriteError(ctx, w, http.StatusBadRequest, CodeInvalidQuery, err.Error())
+		return
+	}
+
+	soft := d.CollectionConfig(collName).SoftDelete && r.URL.Query().Get("hard") != "true"
+
+	deleted := 0
+	errs := []interface{}{}
+
+	for _, id := range ids {
+		removed := true
+		if soft {
+			var doc map[string]interface{}
+			if doc, err = coll.Read(id); err != nil {
+				// The document was removed in the meantime.
+				continue
+			}
+			removed, err = d.SoftDeleteDocument(collName, coll, id, doc)
+		} else {
+			err = d.DeleteDocument(collName, coll, id)
+		}
+
+		if err != nil {
+			errs = append(errs, map[string]interface{}{
+				"id":    id,
+				"error": NewError(CodeInternal, "could not delete document: "+err.Error()),
+			})
+			continue
+		}
+		if removed {
+			deleted++
+		}
+	}
+
+	Logger(ctx).Info("deleted documents by query", "collection", collName, "deleted", deleted, "failed", len(errs))
+
+	WriteResponse(ctx, w, http.StatusOK, map[string]interface{}{
+		"deleted": deleted,
+		"failed":  len(errs),
+		"errors":  errs,
+	})
+}
+
+// TruncateCollectionHandler handles: POST /db/:collection/truncate.
+// Deletes all documents of the collection but keeps the collection, its indexes
+// and its settings. Soft deletes do not apply, documents are removed permanently.
+// A failing deletion does not abort the others.
+func (d *DBController) TruncateCollectionHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	collName := pat.Param(ctx, "collection")
+
+	coll := d.UseCollection(ctx, w)
+	if coll == nil {
+		return
+	}
+
+	// Collect the ids first, deleting while iterating is not supported by Tiedot.
+	ids := []int{}
+	coll.ForEachDoc(func(id int, _ []byte) bool {
+		ids = append(ids, id)
+		return true
+	})
+
+	deleted := 0
+	errs := []interface{}{}
+
+	for _, id := range ids {
+		if err := d.DeleteDocument(collName, coll, id); err != nil {
+			errs = append(errs, map[string]interface{}{
+				"id":    id,
+				"error": NewError(CodeInternal, "could not delete document: "+err.Error()),
+			})
+			continue
+		}
+		deleted++
+	}
+
+	Logger(ctx).Info("truncated collection", "collection", collName, "deleted", deleted, "failed", len(errs))
+
+	WriteResponse(ctx, w, http.StatusOK, map[string]interface{}{
+		"deleted": deleted,
+		"failed":  len(errs),
+		"errors":  errs,
+	})
+}
+
+// MultiReadDocumentsHandler handles: POST /db/:collection/mget.
+// Reads all documents with the given ids. The documents are returned in
+// request order with null for missing ones, which are also listed in errors.
+// Ids may be sent as numbers or strings.
+// Payload example:
+//
+//	{
+//	  "ids": [1, 2, 3]
+//	}
+func (d *DBController) MultiReadDocumentsHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	collName := pat.Param(ctx, "collection")
+
+	coll := d.UseCollection(ctx, w)
+	if coll == nil {
+		return
+	}
+
+	// Parse JSON object from POST parameter. The ids are kept raw to read them exactly.
+	d.LimitBody(w, r)
+	js := map[string]json.RawMessage{}
+	if err := json.NewDecoder(r.Body).Decode(&js); err != nil {
+		WriteBodyError(ctx, w, err)
+		return
+	}
+
+	var rawIDs []json.RawMessage
+	if err := json.Unmarshal(js["ids"], &rawIDs); err != nil || rawIDs == nil {
+		WriteError(ctx, w, http.StatusBadRequest, CodeBadRequest, "ids must be an array of positive integers, as numbers or strings")
+		return
+	}
+
+	ids := make([]int, len(rawIDs))
+	for i, raw := range rawIDs {
+		id, ok := ParseJSONID(raw)
+		if !ok {
+			WriteError(ctx, w, http.StatusBadRequest, CodeBadRequest, "ids must be an array of positive integers, as numbers or strings")
+			return
+		}
+		ids[i] = id
+	}
+
+	docs := make([]interface{}, len(ids))
+	errs := []interface{}{}
+
+	hideDeleted := d.hideDeleted(collName, r)
+
+	for i, id := range ids {
+		doc, err := coll.Read(id)
+		if err != nil || (hideDeleted && IsDeleted(doc)) {
+			errs = append(errs, map[string]interface{}{
+				"id":    id,
+				"error": NewError(CodeDocumentNotFound, "document "+strconv.Itoa(id)+" not found"),
+			})
+			continue
+		}
+		docs[i] = doc
+	}
+
+	WriteResponse(ctx, w, http.StatusOK, map[string]interface{}{
+		"results": d.ResponseDocuments(collName, r, docs),
+		"errors":  errs,
+	})
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestMultiReadDocumentsHandler(t *testing.T) {
+	d := newTestController(t)
+	if err := d.DB.Create("books"); err != nil {
+		t.Fatal(err)
+	}
+	id := createDocument(t, d, "books", `{"title": "Dune"}`)
+
+	tests := []struct {
+		name string
+		ids  string
+	}{
+		{name: "number", ids: id},
+		{name: "string", ids: `"` + id + `"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(t, d, "POST", "/db/books/mget", `{"ids": [`+tt.ids+`]}`)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %s", w.Code, w.Body.String())
+			}
+
+			body := decode(t, w)
+			if errs := body["errors"].([]interface{}); len(errs) != 0 {
+				t.Fatalf("errors = %v", errs)
+			}
+			doc, _ := body["results"].([]interface{})[0].(map[string]interface{})
+			if doc["id"] != id || doc["title"] != "Dune" {
+				t.Errorf("result = %v, want document %s", doc, id)
+			}
+		})
+	}
+}
+
+func TestBatchUpdateDocumentsHandler(t *testing.T) {
+	d := newTestController(t)
+	if err := d.DB.Create("books"); err != nil {
+		t.Fatal(err)
+	}
+	first := createDocument(t, d, "books", `{"title": "Dune", "status": "open"}`)
+	second := createDocument(t, d, "books", `{"title": "Emma", "status": "open"}`)
+
+	w := serve(t, d, "POST", "/db/books/batch-update", `[
+		{"id": `+first+`, "patch": {"status": "done"}},
+		{"id": "`+second+`", "patch": {"status": "done"}}
+	]`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body.String())
+	}
+	if body := decode(t, w); body["updated"] != float64(2) {
+		t.Fatalf("response = %v, want 2 updated", body)
+	}
+
+	for _, id := range []string{first, second} {
+		doc := decode(t, serve(t, d, "GET", "/db/books/"+id, ""))
+		if doc["status"] != "done" {
+			t.Errorf("document %s = %v, want status done", id, doc)
+		}
+	}
+}
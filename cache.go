@@ -0,0 +1,147 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/HouzuoGuo/tiedot/db"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var cacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "crudmachine_cache_requests_total",
+	Help: "Number of document reads answered from the cache (hit) or the database (miss).",
+}, []string{"result"})
+
+func init() {
+	prometheus.MustRegister(cacheRequests)
+}
+
+// cacheKey identifies a cached document.
+type cacheKey struct {
+	collName string
+	id       int
+}
+
+// cacheEntry is the list element value of a cached document.
+type cacheEntry struct {
+	key cacheKey
+	doc map[string]interface{}
+}
+
+// DocumentCache is a LRU cache of documents keyed by collection and id.
+// Cached documents are shared and must not be modified.
+// A nil *DocumentCache is a disabled cache.
+type DocumentCache struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List
+	items map[cacheKey]*list.Element
+	// gen is increased by every removal, so documents read before a write
+	// cannot be added after it evicted them (see Add).
+	gen uint64
+}
+
+// NewDocumentCache creates a cache holding at most size documents.
+func NewDocumentCache(size int) *DocumentCache {
+	return &DocumentCache{
+		size:  size,
+		order: list.New(),
+		items: map[cacheKey]*list.Element{},
+	}
+}
+
+// Get returns the cached document and marks it as recently used. On a miss the
+// returned generation must be passed to Add with the document read from the database.
+func (c *DocumentCache) Get(collName string, id int) (doc map[string]interface{}, gen uint64, ok bool) {
+	if c == nil {
+		return nil, 0, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.items[cacheKey{collName, id}]
+	if !ok {
+		cacheRequests.WithLabelValues("miss").Inc()
+		return nil, c.gen, false
+	}
+
+	cacheRequests.WithLabelValues("hit").Inc()
+	c.order.MoveToFront(e)
+	return e.Value.(*cacheEntry).doc, c.gen, true
+}
+
+// Add caches the document unless any document was removed since gen was returned by Get.
+// The least recently used document is dropped if the cache is full.
+func (c *DocumentCache) Add(collName string, id int, doc map[string]interface{}, gen uint64) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := cacheKey{collName, id}
+	if gen != c.gen || c.items[key] != nil {
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&cacheEntry{key: key, doc: doc})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// Remove evicts the document.
+func (c *DocumentCache) Remove(collName string, id int) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	if e, ok := c.items[cacheKey{collName, id}]; ok {
+		c.order.Remove(e)
+		delete(c.items, e.Value.(*cacheEntry).key)
+	}
+}
+
+// RemoveCollection evicts all documents of the collection.
+func (c *DocumentCache) RemoveCollection(collName string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	for key, e := range c.items {
+		if key.collName == collName {
+			c.order.Remove(e)
+			delete(c.items, key)
+		}
+	}
+}
+
+// readDocument reads the document through the cache of the controller.
+// The returned document may be shared and must not be modified.
+func (d *DBController) readDocument(collName string, coll *db.Col, id int) (map[string]interface{}, error) {
+	doc, gen, ok := d.Cache.Get(collName, id)
+	if ok {
+		return doc, nil
+	}
+
+	doc, err := coll.Read(id)
+	if err != nil {
+		return nil, err
+	}
+
+	d.Cache.Add(collName, id, doc, gen)
+	return doc, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestDocumentCache(t *testing.T) {
+	c := NewDocumentCache(2)
+
+	for id := 1; id <= 3; id++ {
+		_, gen, _ := c.Get("books", id)
+		c.Add("books", id, map[string]interface{}{"n": id}, gen)
+	}
+	if _, _, ok := c.Get("books", 1); ok {
+		t.Error("least recently used document 1 is still cached")
+	}
+
+	// A document read before a write must not be cached after the write evicted it.
+	_, gen, _ := c.Get("books", 4)
+	c.Remove("books", 4)
+	c.Add("books", 4, map[string]interface{}{"n": 4}, gen)
+	if _, _, ok := c.Get("books", 4); ok {
+		t.Error("stale document 4 was cached")
+	}
+
+	c.RemoveCollection("books")
+	for id := 1; id <= 4; id++ {
+		if _, _, ok := c.Get("books", id); ok {
+			t.Errorf("document %d is cached after RemoveCollection", id)
+		}
+	}
+
+	var disabled *DocumentCache
+	disabled.Add("books", 1, map[string]interface{}{}, 0)
+	if _, _, ok := disabled.Get("books", 1); ok {
+		t.Error("disabled cache returned a document")
+	}
+}
+
+func TestReadDocumentCached(t *testing.T) {
+	d := newTestController(t)
+	d.Cache = NewDocumentCache(10)
+	if err := d.DB.Create("books"); err != nil {
+		t.Fatal(err)
+	}
+	id := createDocument(t, d, "books", `{"title": "Dune", "edition": 1}`)
+
+	// Every write is followed by a read that must not return the cached version.
+	steps := []struct {
+		method  string
+		path    string
+		body    string
+		edition interface{} // nil means the document must be gone.
+	}{
+		{method: "PUT", path: "/db/books/" + id, body: `{"title": "Dune", "edition": 2}`, edition: 2.0},
+		{method: "PATCH", path: "/db/books/" + id, body: `{"edition": 3}`, edition: 3.0},
+		{method: "POST", path: "/db/books/" + id + "/incr", body: `{"field": "edition"}`, edition: 4.0},
+		{method: "POST", path: "/db/books/batch-update", body: `[{"id": "` + id + `", "patch": {"edition": 5}}]`, edition: 5.0},
+		{method: "POST", path: "/db/books/truncate"},
+	}
+
+	for _, s := range steps {
+		// Fill the cache with the current version.
+		if w := serve(t, d, "GET", "/db/books/"+id, ""); w.Code != http.StatusOK {
+			t.Fatalf("GET before %s %s: status = %d, want %d", s.method, s.path, w.Code, http.StatusOK)
+		}
+		if w := serve(t, d, s.method, s.path, s.body); w.Code != http.StatusOK {
+			t.Fatalf("%s %s: status = %d, want %d", s.method, s.path, w.Code, http.StatusOK)
+		}
+
+		w := serve(t, d, "GET", "/db/books/"+id, "")
+		if s.edition == nil {
+			if w.Code != http.StatusNotFound {
+				t.Errorf("GET after %s %s: status = %d, want %d", s.method, s.path, w.Code, http.StatusNotFound)
+			}
+			continue
+		}
+		if got := decode(t, w)["edition"]; got != s.edition {
+			t.Errorf("edition after %s %s = %v, want %v", s.method, s.path, got, s.edition)
+		}
+	}
+}
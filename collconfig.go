@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Names of the fields maintained by the server if timestamps or soft deletes are enabled.
+const (
+	CreatedAtField = "created_at"
+	UpdatedAtField = "updated_at"
+	DeletedAtField = "deleted_at"
+)
+
+// CollectionConfig holds the settings of a collection from the collections config file.
+type CollectionConfig struct {
+	// Schema is used to validate created and updated documents. nil means no validation.
+	Schema *Schema
+	// Timestamps enables the server-side created_at and updated_at fields.
+	Timestamps bool
+	// SoftDelete makes deletes set deleted_at instead of removing the document.
+	SoftDelete bool
+	// Unique holds the paths of fields whose values must be unique in the collection.
+	Unique [][]string
+	// Hidden holds the prefixes of top-level fields left out of read responses.
+	Hidden []string
+	// Sort is the order of collection reads without 'sort' param. nil means by id.
+	Sort []SortKey
+	// Defaults holds the values of top-level fields missing in created documents.
+	Defaults map[string]interface{}
+	// Key is the path of the natural key used by conditional creates. nil means none.
+	Key []string
+}
+
+// ParseOptions applies the options following the collection name in a line
+// of the collections config file. Supported options:
+//
+//	timestamps    maintain created_at and updated_at fields
+//	softdelete    mark deleted documents with deleted_at instead of removing them
+//	unique=a,b.c  reject documents whose value of field a or b.c is already used
+//	hidden=_,x_   leave fields starting with _ or x_ out of read responses
+//	sort=a:desc,b order collection reads by a descending and b ascending by default
+//	default=a=v   set field a of created documents to v if missing, v is parsed as
+//	              JSON if possible and used as string otherwise, may be repeated
+//	key=a.b       natural key checked by creates with If-None-Match: *
+func (c *CollectionConfig) ParseOptions(options []string) error {
+	for _, o := range options {
+		name, value, _ := strings.Cut(o, "=")
+
+		switch name {
+		case "timestamps":
+			c.Timestamps = true
+		case "softdelete":
+			c.SoftDelete = true
+		case "unique":
+			for _, field := range strings.Split(value, ",") {
+				path := strings.Split(field, ".")
+				for _, p := range path {
+					if p == "" {
+						return fmt.Errorf("invalid unique field '%s'", field)
+					}
+				}
+				c.Unique = append(c.Unique, path)
+			}
+		case "hidden":
+			for _, prefix := range strings.Split(value, ",") {
+				if prefix == "" {
+					return fmt.Errorf("invalid hidden prefix in '%s'", o)
+				}
+				c.Hidden = append(c.Hidden, prefix)
+			}
+		case "sort":
+			keys, err := ParseSortOption(value)
+			if err != nil {
+				return err
+			}
+			c.Sort = keys
+		case "key":
+			path := strings.Split(value, ".")
+			for _, p := range path {
+				if p == "" {
+					return fmt.Errorf("invalid key field '%s'", value)
+				}
+			}
+			c.Key = path
+		case "default":
+			field, raw, ok := strings.Cut(value, "=")
+			if !ok || field == "" {
+				return fmt.Errorf("invalid default '%s', expected default=field=value", o)
+			}
+			var v interface{}
+			if err := json.Unmarshal([]byte(raw), &v); err != nil {
+				v = raw
+			}
+			if c.Defaults == nil {
+				c.Defaults = map[string]interface{}{}
+			}
+			c.Defaults[field] = v
+		default:
+			return fmt.Errorf("unknown option '%s'", o)
+		}
+	}
+	return nil
+}
+
+// CollectionConfig returns the settings of the collection.
+// Collections that are not configured get the default settings.
+func (d *DBController) CollectionConfig(collName string) *CollectionConfig {
+	if c, ok := d.Collections[collName]; ok {
+		return c
+	}
+	return &CollectionConfig{}
+}
+
+// timestamps reports whether timestamps are enabled for the collection.
+func (d *DBController) timestamps(collName string) bool {
+	return d.Timestamps || d.CollectionConfig(collName).Timestamps
+}
+
+// hideDeleted reports whether soft-deleted documents of the collection must be
+// left out of the response. Clients can include them with ?includeDeleted=true.
+func (d *DBController) hideDeleted(collName string, r *http.Request) bool {
+	return d.CollectionConfig(collName).SoftDelete && r.URL.Query().Get("includeDeleted") != "true"
+}
+
+// hiddenPrefixes returns the prefixes of fields that must be left out of read
+// responses of the collection. Clients get all fields with ?raw=true.
+func (d *DBController) hiddenPrefixes(collName string, r *http.Request) []string {
+	if r.URL.Query().Get("raw") == "true" {
+		return nil
+	}
+	return d.CollectionConfig(collName).Hidden
+}
+
+// IsDeleted reports whether the document was soft-deleted.
+func IsDeleted(doc map[string]interface{}) bool {
+	v, ok := doc[DeletedAtField]
+	return ok && v != nil
+}
+
+// UpdatedAfter reports whether the updated_at field of the document is after t.
+// Documents without a valid updated_at are never updated after t.
+func UpdatedAfter(doc map[string]interface{}, t time.Time) bool {
+	v, ok := doc[UpdatedAtField].(string)
+	if !ok {
+		return false
+	}
+	updatedAt, err := time.Parse(time.RFC3339, v)
+	return err == nil && updatedAt.After(t)
+}
+
+// now returns the current time formatted for timestamp fields.
+func now() string {
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
+// ApplyDefaults sets the default values of the collection for all top-level
+// fields missing in a new document. Values sent by the client always win.
+func (d *DBController) ApplyDefaults(collName string, doc map[string]interface{}) {
+	for field, v := range d.CollectionConfig(collName).Defaults {
+		if _, ok := doc[field]; !ok {
+			// Objects and arrays must not be shared between documents.
+			doc[field] = copyValue(v)
+		}
+	}
+}
+
+// StampCreated sets created_at and updated_at of a new document if timestamps
+// are enabled for the collection. Values sent by the client are overwritten.
+func (d *DBController) StampCreated(collName string, doc map[string]interface{}) {
+	if !d.timestamps(collName) {
+		return
+	}
+
+	t := now()
+	doc[CreatedAtField] = t
+	doc[UpdatedAtField] = t
+}
+
+// StampUpdated sets updated_at of an updated document and keeps created_at of
+// the existing document if timestamps are enabled for the collection.
+// existing may be nil if the document did not exist before.
+// Values sent by the client are overwritten.
+func (d *DBController) StampUpdated(collName string, doc, existing map[string]interface{}) {
+	if !d.timestamps(collName) {
+		return
+	}
+
+	t := now()
+	if existing == nil {
+		doc[CreatedAtField] = t
+	} else if createdAt, ok := existing[CreatedAtField]; ok {
+		doc[CreatedAtField] = createdAt
+	} else {
+		delete(doc, CreatedAtField)
+	}
+	doc[UpdatedAtField] = t
+}
+
+// splitConfigLine splits a line of the collections config file of the form
+// 'name[:schemafile] [option ...]' into its parts.
+// Blank lines and comments starting with '#' return an empty name.
+func splitConfigLine(line string) (collName, schemaFile string, options []string) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+		return "", "", nil
+	}
+
+	collName, schemaFile, _ = strings.Cut(fields[0], ":")
+	return collName, schemaFile, fields[1:]
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitConfigLine(t *testing.T) {
+	tests := []struct {
+		line        string
+		wantName    string
+		wantSchema  string
+		wantOptions []string
+	}{
+		{line: ""},
+		{line: "   \t"},
+		{line: "# comment"},
+		{line: "  #books"},
+		{line: "books", wantName: "books", wantOptions: []string{}},
+		{line: "  books  ", wantName: "books", wantOptions: []string{}},
+		{line: "books:books.json timestamps", wantName: "books", wantSchema: "books.json", wantOptions: []string{"timestamps"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			name, schema, options := splitConfigLine(tt.line)
+			if name != tt.wantName || schema != tt.wantSchema || !reflect.DeepEqual(options, tt.wantOptions) {
+				t.Errorf("splitConfigLine(%q) = %q, %q, %q, want %q, %q, %q",
+					tt.line, name, schema, options, tt.wantName, tt.wantSchema, tt.wantOptions)
+			}
+		})
+	}
+}
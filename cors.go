@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"goji.io"
+	"golang.org/x/net/context"
+)
+
+const (
+	corsAllowMethods  = "GET, POST, PUT, PATCH, DELETE, OPTIONS"
+	corsAllowHeaders  = "Authorization, Content-Type, If-Match, If-None-Match, " + RequestIDHeader
+	corsExposeHeaders = "ETag, Location, " + RequestIDHeader
+)
+
+// CORS returns a middleware that sets the CORS headers for requests from
+// one of the allowed origins. An origin of "*" allows all origins.
+// Preflight OPTIONS requests are answered directly with 204.
+func CORS(allowedOrigins []string) func(goji.Handler) goji.Handler {
+	allowed := map[string]bool{}
+	for _, o := range allowedOrigins {
+		if o = strings.TrimSpace(o); o != "" {
+			allowed[o] = true
+		}
+	}
+
+	return func(h goji.Handler) goji.Handler {
+		return goji.HandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin != "" && (allowed["*"] || allowed[origin]) {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Access-Control-Allow-Methods", corsAllowMethods)
+				w.Header().Set("Access-Control-Allow-Headers", corsAllowHeaders)
+				w.Header().Set("Access-Control-Expose-Headers", corsExposeHeaders)
+				w.Header().Add("Vary", "Origin")
+			}
+
+			if r.Method == http.MethodOptions {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			h.ServeHTTPC(ctx, w, r)
+		})
+	}
+}
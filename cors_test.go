@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"goji.io"
+	"goji.io/pat"
+	"golang.org/x/net/context"
+)
+
+func TestCORSPreconditionHeaders(t *testing.T) {
+	mux := goji.NewMux()
+	mux.UseC(CORS([]string{"https://app.example.com"}))
+	mux.HandleFuncC(pat.Put("/db/books/1"), func(ctx context.Context, w http.ResponseWriter, r *http.Request) {})
+
+	r := httptest.NewRequest("OPTIONS", "/db/books/1", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	r.Header.Set("Access-Control-Request-Method", "PUT")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+
+	tests := []struct {
+		header string
+		want   []string
+	}{
+		{header: "Access-Control-Allow-Headers", want: []string{"If-Match", "If-None-Match"}},
+		{header: "Access-Control-Expose-Headers", want: []string{"ETag", "Location"}},
+	}
+
+	for _, tt := range tests {
+		got := strings.Split(w.Header().Get(tt.header), ", ")
+		for _, want := range tt.want {
+			found := false
+			for _, h := range got {
+				found = found || h == want
+			}
+			if !found {
+				t.Errorf("%s = %v, missing %s", tt.header, got, want)
+			}
+		}
+	}
+}
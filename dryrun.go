@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net/http"
+
+	"golang.org/x/net/context"
+)
+
+// IsDryRun reports whether the write request only asks for validation via ?dryRun=true.
+// Dry runs perform all checks but never insert or update documents.
+func IsDryRun(r *http.Request) bool {
+	return r.URL.Query().Get("dryRun") == "true"
+}
+
+// WriteDryRun responds with the document that would have been stored,
+// marked with "dryRun": true.
+func WriteDryRun(ctx context.Context, w http.ResponseWriter, doc map[string]interface{}) {
+	resp := make(map[string]interface{}, len(doc)+1)
+	for k, v := range doc {
+		resp[k] = v
+	}
+	resp["dryRun"] = true
+
+	WriteResponse(ctx, w, http.StatusOK, resp)
+}
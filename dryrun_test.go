@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// snapshot returns all documents of the collection by id.
+func snapshot(d *DBController, collName string) map[int]map[string]interface{} {
+	docs := map[int]map[string]interface{}{}
+	ForEachDocument(d.DB.Use(collName), func(id int, doc map[string]interface{}) bool {
+		docs[id] = doc
+		return true
+	})
+	return docs
+}
+
+func TestDryRun(t *testing.T) {
+	d := newTestController(t)
+	d.AutoCreate = true
+	if err := d.SetupCollections(writeConfig(t, "books unique=isbn\n"), SetupOptions{}); err != nil {
+		t.Fatalf("SetupCollections() error = %v", err)
+	}
+	id := createDocument(t, d, "books", `{"title": "Dune", "isbn": "0815"}`)
+	before := snapshot(d, "books")
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{method: "POST", path: "/db/books?dryRun=true", body: `{"title": "Emma"}`, want: http.StatusOK},
+		{method: "POST", path: "/db/books?dryRun=true", body: `{"title": "Emma", "isbn": "0815"}`, want: http.StatusConflict},
+		{method: "POST", path: "/db/books?dryRun=true", body: `[{"title": "Emma"}, {"title": "Ulysses"}]`, want: http.StatusOK},
+		{method: "POST", path: "/db/books/bulk?dryRun=true", body: `[{"title": "Emma"}]`, want: http.StatusOK},
+		{method: "PUT", path: "/db/books/" + id + "?dryRun=true", body: `{"title": "Dune Messiah"}`, want: http.StatusOK},
+		{method: "PUT", path: "/db/books/4711?dryRun=true", body: `{"title": "Emma"}`, want: http.StatusOK},
+		{method: "PATCH", path: "/db/books/" + id + "?dryRun=true", body: `{"title": "Dune Messiah"}`, want: http.StatusOK},
+		{method: "POST", path: "/db/books/batch-update?dryRun=true", body: `[{"id": ` + id + `, "patch": {"title": "Dune Messiah"}}]`, want: http.StatusOK},
+		{method: "POST", path: "/db/authors?dryRun=true", body: `{"name": "Herbert"}`, want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		w := serve(t, d, tt.method, tt.path, tt.body)
+		if w.Code != tt.want {
+			t.Errorf("%s %s %s: status = %d, want %d", tt.method, tt.path, tt.body, w.Code, tt.want)
+			continue
+		}
+		if w.Code == http.StatusOK && decode(t, w)["dryRun"] != true {
+			t.Errorf("%s %s: response is not marked as dry run", tt.method, tt.path)
+		}
+	}
+
+	if after := snapshot(d, "books"); !reflect.DeepEqual(after, before) {
+		t.Errorf("documents = %v, want %v", after, before)
+	}
+	if got := collections(d); !reflect.DeepEqual(got, []string{"books"}) {
+		t.Errorf("collections = %v, want [books]", got)
+	}
+}
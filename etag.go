@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// DocumentETag returns a strong ETag for the representation of the document
+// that a read of the collection with the request's parameters returns.
+// JSON encoding sorts map keys, so the tag only depends on the document content,
+// the hidden fields, the 'fields' projection, the response format and pretty printing.
+func (d *DBController) DocumentETag(ctx context.Context, collName string, r *http.Request, doc map[string]interface{}) string {
+	b, err := json.Marshal(d.ResponseDocument(collName, r, doc))
+	if err != nil {
+		return ""
+	}
+
+	h := sha1.New()
+	h.Write(b)
+	if PrettyResponse(ctx) {
+		h.Write([]byte("\npretty"))
+	}
+	h.Write([]byte("\n" + ResponseFormat(ctx)))
+	return `"` + hex.EncodeToString(h.Sum(nil)) + `"`
+}
+
+// SetETag sets the ETag header of a response carrying the document and returns the tag.
+// Responses vary by the negotiated format, so caches must key them by Accept.
+func (d *DBController) SetETag(ctx context.Context, w http.ResponseWriter, collName string, r *http.Request, doc map[string]interface{}) string {
+	etag := d.DocumentETag(ctx, collName, r, doc)
+	w.Header().Set("ETag", etag)
+	w.Header().Add("Vary", "Accept")
+	return etag
+}
+
+// MatchETag reports whether the ETag matches one of the entity tags in the
+// given If-Match or If-None-Match header value. "*" matches any ETag.
+// If-None-Match uses the weak comparison, which ignores the W/ prefix.
+// If-Match requires the strong comparison, where weak tags never match.
+func MatchETag(header, etag string, strong bool) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" {
+			return true
+		}
+		if strings.HasPrefix(t, "W/") {
+			if strong {
+				continue
+			}
+			t = strings.TrimPrefix(t, "W/")
+		}
+		if t == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckIfMatch checks the If-Match precondition of the request against the
+// current document, which is nil if it does not exist.
+// Requests without If-Match header always pass.
+func (d *DBController) CheckIfMatch(ctx context.Context, collName string, r *http.Request, doc map[string]interface{}) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return true
+	}
+	if doc == nil {
+		return false
+	}
+	return MatchETag(header, d.DocumentETag(ctx, collName, r, doc), true)
+}
+
+// CheckPreconditions checks the If-Match precondition of an update of the document
+// with the given id against the current document, which is nil if it does not exist.
+// With RequireIfMatch updates of existing documents without If-Match header are
+// answered with 428, else mismatches with 412. False is returned if a response was written.
+func (d *DBController) CheckPreconditions(ctx context.Context, w http.ResponseWriter, r *http.Request, collName, strid string, doc map[string]interface{}) bool {
+	if d.RequireIfMatch && doc != nil && r.Header.Get("If-Match") == "" {
+		WriteError(ctx, w, http.StatusPreconditionRequired, CodePreconditionRequired, "updates of document "+strid+" require an If-Match header")
+		return false
+	}
+	if !d.CheckIfMatch(ctx, collName, r, doc) {
+		WriteError(ctx, w, http.StatusPreconditionFailed, CodePreconditionFailed, "document "+strid+" does not match If-Match")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMatchETag(t *testing.T) {
+	tests := []struct {
+		header string
+		strong bool
+		want   bool
+	}{
+		{header: `"abc"`, strong: true, want: true},
+		{header: `"abc"`, strong: false, want: true},
+		{header: `W/"abc"`, strong: true, want: false},
+		{header: `W/"abc"`, strong: false, want: true},
+		{header: `"xyz", W/"abc"`, strong: true, want: false},
+		{header: `"xyz", "abc"`, strong: true, want: true},
+		{header: `*`, strong: true, want: true},
+		{header: `"xyz"`, strong: false, want: false},
+	}
+
+	for _, tt := range tests {
+		if got := MatchETag(tt.header, `"abc"`, tt.strong); got != tt.want {
+			t.Errorf("MatchETag(%s, strong=%t) = %t, want %t", tt.header, tt.strong, got, tt.want)
+		}
+	}
+}
+
+func TestDocumentETagIfMatch(t *testing.T) {
+	d := newTestController(t)
+	if err := d.DB.Create("books"); err != nil {
+		t.Fatal(err)
+	}
+	id := createDocument(t, d, "books", `{"title": "Dune", "edition": 1}`)
+
+	etag := serve(t, d, "GET", "/db/books/"+id, "").Header().Get("ETag")
+	if etag == "" || strings.HasPrefix(etag, "W/") {
+		t.Fatalf("ETag = %q, want a strong tag", etag)
+	}
+	projected := serve(t, d, "GET", "/db/books/"+id+"?fields=title", "").Header().Get("ETag")
+	if projected == etag {
+		t.Errorf("ETag of the projection = %q, want a different tag", projected)
+	}
+
+	r := newRequest("GET", "/db/books/"+id, "")
+	r.Header.Set("If-None-Match", "W/"+etag)
+	if w := serveRequest(t, d, r); w.Code != http.StatusNotModified {
+		t.Errorf("GET with weak If-None-Match: status = %d, want %d", w.Code, http.StatusNotModified)
+	}
+
+	tests := []struct {
+		name    string
+		ifMatch string
+		want    int
+	}{
+		{name: "weak", ifMatch: "W/" + etag, want: http.StatusPreconditionFailed},
+		{name: "other representation", ifMatch: projected, want: http.StatusPreconditionFailed},
+		{name: "strong", ifMatch: etag, want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		r := newRequest("PATCH", "/db/books/"+id, `{"edition": 2}`)
+		r.Header.Set("If-Match", tt.ifMatch)
+		if w := serveRequest(t, d, r); w.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
+
+func TestCheckPreconditions(t *testing.T) {
+	tests := []struct {
+		name           string
+		requireIfMatch bool
+		path           string
+		ifMatch        string
+		want           int
+	}{
+		{name: "no If-Match", path: "/db/books/{id}", want: http.StatusOK},
+		{name: "required", requireIfMatch: true, path: "/db/books/{id}", want: http.StatusPreconditionRequired},
+		{name: "stale", path: "/db/books/{id}", ifMatch: `"stale"`, want: http.StatusPreconditionFailed},
+		{name: "any", requireIfMatch: true, path: "/db/books/{id}", ifMatch: "*", want: http.StatusOK},
+		{name: "create", requireIfMatch: true, path: "/db/books/4711", want: http.StatusCreated},
+		{name: "create with any", path: "/db/books/4711", ifMatch: "*", want: http.StatusPreconditionFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestController(t)
+			d.RequireIfMatch = tt.requireIfMatch
+			if err := d.DB.Create("books"); err != nil {
+				t.Fatal(err)
+			}
+			id := createDocument(t, d, "books", `{"title": "Dune", "edition": 1}`)
+			path := strings.Replace(tt.path, "{id}", id, 1)
+
+			r := newRequest("PUT", path, `{"title": "Dune", "edition": 2}`)
+			if tt.ifMatch != "" {
+				r.Header.Set("If-Match", tt.ifMatch)
+			}
+			w := serveRequest(t, d, r)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d", w.Code, tt.want)
+			}
+
+			// A failed precondition must leave the document unchanged.
+			want := 2.0
+			if w.Code >= 400 {
+				want = 1
+			}
+			if got := decode(t, serve(t, d, "GET", "/db/books/"+id, ""))["edition"]; got != want && path != tt.path {
+				t.Errorf("edition = %v, want %v", got, want)
+			}
+		})
+	}
+}
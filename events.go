@@ -0,0 +1,115 @@
+package main
+
+import (
+	"sync"
+)
+
+// Types of change events.
+const (
+	EventCreated = "created"
+	EventUpdated = "updated"
+	EventDeleted = "deleted"
+)
+
+// eventBufferSize is the number of events buffered per subscriber.
+const eventBufferSize = 64
+
+// Event describes a change of a document made through the API.
+type Event struct {
+	Type       string                 `json:"type"`
+	Collection string                 `json:"collection"`
+	ID         int                    `json:"id"`
+	Document   map[string]interface{} `json:"document,omitempty"`
+}
+
+// EventBus is an in-process pub/sub for change events. Write handlers publish
+// events and watchers subscribe to the events of a collection.
+// Only changes made through this server are published.
+type EventBus struct {
+	mu   sync.Mutex
+	subs map[string]map[chan Event]bool
+
+	// done is closed by Close to end all streams.
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewEventBus creates an EventBus without subscribers.
+func NewEventBus() *EventBus {
+	return &EventBus{
+		subs: map[string]map[chan Event]bool{},
+		done: make(chan struct{}),
+	}
+}
+
+// Close tells all subscribers to end their streams by closing the Done channel.
+// Long-lived streams would otherwise keep a graceful shutdown of the server waiting.
+func (b *EventBus) Close() {
+	b.closeOnce.Do(func() { close(b.done) })
+}
+
+// Done returns a channel that is closed when the bus is closed.
+func (b *EventBus) Done() <-chan struct{} {
+	return b.done
+}
+
+// Subscribe returns a channel receiving the events of the collection and a
+// function to cancel the subscription. The channel is closed when the
+// subscription is cancelled or the subscriber falls too far behind, so
+// subscribers never miss events without noticing.
+func (b *EventBus) Subscribe(collName string) (<-chan Event, func()) {
+	ch := make(chan Event, eventBufferSize)
+
+	b.mu.Lock()
+	if b.subs[collName] == nil {
+		b.subs[collName] = map[chan Event]bool{}
+	}
+	b.subs[collName][ch] = true
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		b.unsubscribe(collName, ch)
+		b.mu.Unlock()
+	}
+}
+
+// unsubscribe removes the subscriber and closes its channel.
+// b.mu must be held.
+func (b *EventBus) unsubscribe(collName string, ch chan Event) {
+	if !b.subs[collName][ch] {
+		return
+	}
+	delete(b.subs[collName], ch)
+	if len(b.subs[collName]) == 0 {
+		delete(b.subs, collName)
+	}
+	close(ch)
+}
+
+// Publish sends the event to all subscribers of its collection without blocking.
+// Subscribers whose buffer is full are dropped.
+func (b *EventBus) Publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subs[e.Collection] {
+		select {
+		case ch <- e:
+		default:
+			b.unsubscribe(e.Collection, ch)
+		}
+	}
+}
+
+// publish publishes a change of a document to the event bus of the controller.
+// The document is evicted from the cache, so every write must publish its change.
+func (d *DBController) publish(eventType, collName string, id int, doc map[string]interface{}) {
+	d.Cache.Remove(collName, id)
+	d.Events.Publish(Event{
+		Type:       eventType,
+		Collection: collName,
+		ID:         id,
+		Document:   doc,
+	})
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/HouzuoGuo/tiedot/db"
+)
+
+// ReservedParams are query params with a special meaning on collection reads.
+// They are never interpreted as filters.
+var ReservedParams = map[string]bool{
+	"limit":  true,
+	"offset": true,
+	"sort":   true,
+	"order":  true,
+	"fields": true,
+	"q":      true,
+	"all":    true,
+	"raw":    true,
+
+	"includeDeleted": true,
+	"updatedSince":   true,
+	"pretty":         true,
+}
+
+// Filter is an equality condition on a document field.
+// Values are compared by their string representation, like Tiedot does.
+type Filter struct {
+	Path  []string
+	Value string
+}
+
+// FilterGroup is a disjunction of filters: any of them must match.
+type FilterGroup []Filter
+
+// ParseFilters turns the query params of the request into filter groups,
+// which must all match.
+//
+// All non-reserved params are equality filters: ?status=active&role=admin
+// matches documents where status is "active" and role is "admin". Dotted keys
+// address nested fields, e.g. ?address.city=Berlin matches on the path
+// ["address", "city"].
+//
+// The 'q' param expresses OR conditions with the grammar:
+//
+//	q    = term { "|" term }
+//	term = path ":" value
+//	path = key { "." key }
+//
+// e.g. ?q=status:active|status:pending matches documents where status is
+// "active" or "pending". Values must not contain '|'. Multiple 'q' params
+// are combined with AND.
+func ParseFilters(r *http.Request) ([]FilterGroup, error) {
+	query := r.URL.Query()
+
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		if !ReservedParams[k] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	groups := []FilterGroup{}
+	for _, k := range keys {
+		for _, v := range query[k] {
+			groups = append(groups, FilterGroup{{Path: strings.Split(k, "."), Value: v}})
+		}
+	}
+
+	for _, q := range query["q"] {
+		group, err := ParseOrFilter(q)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+
+	return groups, nil
+}
+
+// ParseOrFilter parses an OR expression like 'status:active|status:pending'.
+func ParseOrFilter(q string) (FilterGroup, error) {
+	group := FilterGroup{}
+
+	for _, term := range strings.Split(q, "|") {
+		path, value, ok := strings.Cut(term, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid filter term '%s': expected path:value", term)
+		}
+
+		keys := strings.Split(path, ".")
+		for _, k := range keys {
+			if k == "" {
+				return nil, fmt.Errorf("invalid filter term '%s': empty field name", term)
+			}
+		}
+
+		group = append(group, Filter{Path: keys, Value: value})
+	}
+
+	return group, nil
+}
+
+// Query returns the Tiedot query for the filter.
+func (f Filter) Query() map[string]interface{} {
+	in := make([]interface{}, len(f.Path))
+	for i, p := range f.Path {
+		in[i] = p
+	}
+
+	return map[string]interface{}{
+		"eq": f.Value,
+		"in": in,
+	}
+}
+
+// Match reports whether the document satisfies the filter.
+// If the path leads to an array, any of its elements may match.
+func (f Filter) Match(doc map[string]interface{}) bool {
+	v, ok := pathValue(doc, f.Path)
+	if !ok {
+		return false
+	}
+
+	if arr, ok := v.([]interface{}); ok {
+		for _, e := range arr {
+			if fmt.Sprint(e) == f.Value {
+				return true
+			}
+		}
+		return false
+	}
+
+	return fmt.Sprint(v) == f.Value
+}
+
+// pathValue returns the value at the path of nested objects in the document.
+func pathValue(doc map[string]interface{}, path []string) (interface{}, bool) {
+	var v interface{} = doc
+	for _, p := range path {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[p]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// Query returns the Tiedot query for the group, which is a union of the filter queries.
+func (g FilterGroup) Query() interface{} {
+	if len(g) == 1 {
+		return g[0].Query()
+	}
+
+	union := make([]interface{}, len(g))
+	for i, f := range g {
+		union[i] = f.Query()
+	}
+	return union
+}
+
+// Match reports whether the document satisfies any filter of the group.
+func (g FilterGroup) Match(doc map[string]interface{}) bool {
+	for _, f := range g {
+		if f.Match(doc) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchFilters reports whether the document satisfies all filter groups.
+func MatchFilters(doc map[string]interface{}, groups []FilterGroup) bool {
+	for _, g := range groups {
+		if !g.Match(doc) {
+			return false
+		}
+	}
+	return true
+}
+
+// SplitFilters separates the filter groups that can be answered by indexes of
+// the collection from those that need a scan of the documents.
+// A group can only use indexes if all its paths are indexed.
+func SplitFilters(coll *db.Col, groups []FilterGroup) (indexed, unindexed []FilterGroup) {
+	for _, g := range groups {
+		allIndexed := true
+		for _, f := range g {
+			if !HasIndex(coll, f.Path) {
+				allIndexed = false
+				break
+			}
+		}
+
+		if allIndexed {
+			indexed = append(indexed, g)
+		} else {
+			unindexed = append(unindexed, g)
+		}
+	}
+	return indexed, unindexed
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseFiltersNested(t *testing.T) {
+	r := httptest.NewRequest("GET", "/db/books?a.b.c=x&limit=10", nil)
+
+	groups, err := ParseFilters(r)
+	if err != nil {
+		t.Fatalf("ParseFilters() error = %v", err)
+	}
+
+	want := []FilterGroup{{{Path: []string{"a", "b", "c"}, Value: "x"}}}
+	if !reflect.DeepEqual(groups, want) {
+		t.Fatalf("ParseFilters() = %v, want %v", groups, want)
+	}
+
+	wantQuery := map[string]interface{}{
+		"eq": "x",
+		"in": []interface{}{"a", "b", "c"},
+	}
+	if got := groups[0].Query(); !reflect.DeepEqual(got, wantQuery) {
+		t.Errorf("Query() = %v, want %v", got, wantQuery)
+	}
+
+	tests := []struct {
+		name string
+		doc  map[string]interface{}
+		want bool
+	}{
+		{
+			name: "nested match",
+			doc:  map[string]interface{}{"a": map[string]interface{}{"b": map[string]interface{}{"c": "x"}}},
+			want: true,
+		},
+		{
+			name: "nested array match",
+			doc:  map[string]interface{}{"a": map[string]interface{}{"b": map[string]interface{}{"c": []interface{}{"y", "x"}}}},
+			want: true,
+		},
+		{
+			name: "other value",
+			doc:  map[string]interface{}{"a": map[string]interface{}{"b": map[string]interface{}{"c": "y"}}},
+		},
+		{
+			name: "one level only",
+			doc:  map[string]interface{}{"a": map[string]interface{}{"b": "x"}},
+		},
+		{
+			name: "dotted top-level key",
+			doc:  map[string]interface{}{"a.b.c": "x"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MatchFilters(tt.doc, groups); got != tt.want {
+				t.Errorf("MatchFilters() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,24 @@
+module github.com/zensword/crudmachine
+
+go 1.21
+
+require (
+	github.com/HouzuoGuo/tiedot v0.0.0-20210905174726-ae1e16866d06
+	github.com/prometheus/client_golang v1.19.0
+	github.com/vmihailenco/msgpack/v5 v5.4.1
+	goji.io v1.1.0
+	golang.org/x/crypto v0.22.0
+	golang.org/x/net v0.24.0
+	golang.org/x/time v0.5.0
+)
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/prometheus/client_model v0.5.0 // indirect
+	github.com/prometheus/common v0.48.0 // indirect
+	github.com/prometheus/procfs v0.12.0 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+	golang.org/x/sys v0.19.0 // indirect
+	google.golang.org/protobuf v1.32.0 // indirect
+)
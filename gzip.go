@@ -0,0 +1,143 @@
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"goji.io"
+	"golang.org/x/net/context"
+)
+
+// gzipMinSize is the minimum size of a response body in bytes to be compressed.
+// Smaller bodies are not worth the overhead.
+const gzipMinSize = 1024
+
+// Gzip is a middleware that compresses response bodies with gzip if the
+// client accepts it. Bodies smaller than gzipMinSize are sent uncompressed.
+func Gzip(h goji.Handler) goji.Handler {
+	return goji.HandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		// Upgraded connections like WebSockets take over the raw connection.
+		if r.Method == http.MethodHead || r.Header.Get("Upgrade") != "" || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			h.ServeHTTPC(ctx, w, r)
+			return
+		}
+
+		// Close is not deferred: during a panic it would send an implicit 200
+		// before a recoverer further out could answer with 500.
+		gw := &gzipWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTPC(ctx, gw, r)
+		gw.Close()
+	})
+}
+
+// acceptsGzip reports whether the Accept-Encoding header value allows gzip.
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		if strings.TrimSpace(coding) != "gzip" {
+			continue
+		}
+
+		// Clients may explicitly refuse gzip with a quality of 0.
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// gzipWriter buffers the beginning of a response body and starts compressing
+// once it exceeds gzipMinSize. Smaller bodies are written uncompressed on Close.
+type gzipWriter struct {
+	http.ResponseWriter
+	status int
+	buf    []byte
+	gz     *gzip.Writer
+	// plain is set once the body is written uncompressed.
+	plain bool
+}
+
+func (w *gzipWriter) WriteHeader(status int) {
+	w.status = status
+}
+
+func (w *gzipWriter) Write(b []byte) (int, error) {
+	if w.gz != nil {
+		return w.gz.Write(b)
+	}
+	if w.plain {
+		return w.ResponseWriter.Write(b)
+	}
+
+	w.buf = append(w.buf, b...)
+	if len(w.buf) >= gzipMinSize {
+		if err := w.startGzip(); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// startGzip writes the header and the buffered body through a gzip writer.
+func (w *gzipWriter) startGzip() error {
+	// Already encoded bodies must not be compressed again.
+	if w.Header().Get("Content-Encoding") != "" {
+		return w.writePlain()
+	}
+
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeader(w.status)
+
+	w.gz = gzip.NewWriter(w.ResponseWriter)
+	_, err := w.gz.Write(w.buf)
+	w.buf = nil
+	return err
+}
+
+// writePlain writes the header and the buffered body uncompressed.
+func (w *gzipWriter) writePlain() error {
+	w.ResponseWriter.WriteHeader(w.status)
+	_, err := w.ResponseWriter.Write(w.buf)
+	w.buf = nil
+	w.plain = true
+	return err
+}
+
+// Flush starts compressing right away so streamed responses reach the client.
+func (w *gzipWriter) Flush() {
+	if w.gz == nil && !w.plain {
+		if err := w.startGzip(); err != nil {
+			return
+		}
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the wrapped http.ResponseWriter for http.ResponseController.
+func (w *gzipWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Close finishes the response. It must be called after the handler returned.
+func (w *gzipWriter) Close() error {
+	if w.gz != nil {
+		return w.gz.Close()
+	}
+	if w.plain {
+		return nil
+	}
+	return w.writePlain()
+}
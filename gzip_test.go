@@ -0,0 +1,76 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"goji.io"
+	"goji.io/pat"
+	"golang.org/x/net/context"
+)
+
+func TestGzip(t *testing.T) {
+	large := strings.Repeat("crudmachine ", gzipMinSize)
+
+	tests := []struct {
+		name           string
+		method         string
+		acceptEncoding string
+		body           string
+		wantGzip       bool
+	}{
+		{name: "large", method: "GET", acceptEncoding: "gzip, deflate", body: large, wantGzip: true},
+		{name: "small", method: "GET", acceptEncoding: "gzip", body: "crudmachine"},
+		{name: "not accepted", method: "GET", acceptEncoding: "deflate", body: large},
+		{name: "refused", method: "GET", acceptEncoding: "gzip;q=0, deflate", body: large},
+		{name: "head", method: "HEAD", acceptEncoding: "gzip", body: large},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := goji.NewMux()
+			mux.UseC(Gzip)
+			handler := func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusCreated)
+				io.WriteString(w, tt.body)
+			}
+			mux.HandleFuncC(pat.Get("/db/books"), handler)
+			mux.HandleFuncC(pat.Head("/db/books"), handler)
+
+			r := httptest.NewRequest(tt.method, "/db/books", nil)
+			r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+
+			if w.Code != http.StatusCreated {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusCreated)
+			}
+			if got := w.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("Vary = %q, want Accept-Encoding", got)
+			}
+			if got := w.Header().Get("Content-Encoding") == "gzip"; got != tt.wantGzip {
+				t.Fatalf("gzip = %t, want %t", got, tt.wantGzip)
+			}
+
+			var body io.Reader = w.Body
+			if tt.wantGzip {
+				gz, err := gzip.NewReader(w.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body = gz
+			}
+			b, err := io.ReadAll(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.body {
+				t.Errorf("body has %d bytes, want %d", len(b), len(tt.body))
+			}
+		})
+	}
+}
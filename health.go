@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"golang.org/x/net/context"
+)
+
+// HealthHandler handles: GET /health.
+// Liveness probe that succeeds as long as the http server is up.
+// It also reports whether the server is in read-only mode.
+func (d *DBController) HealthHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	WriteResponse(ctx, w, http.StatusOK, map[string]interface{}{
+		"status":   "ok",
+		"readonly": d.ReadOnly,
+	})
+}
+
+// ReadyHandler handles: GET /ready.
+// Readiness probe that succeeds only if the database is usable, else 503 is returned.
+func (d *DBController) ReadyHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if err := d.CheckDB(); err != nil {
+		WriteError(ctx, w, http.StatusServiceUnavailable, CodeNotReady, err.Error())
+		return
+	}
+
+	WriteResponse(ctx, w, http.StatusOK, map[string]interface{}{
+		"status": "ready",
+	})
+}
+
+// CheckDB verifies that the database is open and all its collections can be used.
+func (d *DBController) CheckDB() error {
+	if d.DB == nil {
+		return fmt.Errorf("database is not open")
+	}
+
+	for _, collName := range d.DB.AllCols() {
+		if d.DB.Use(collName) == nil {
+			return fmt.Errorf("could not use collection %s", collName)
+		}
+	}
+
+	return nil
+}
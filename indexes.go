@@ -0,0 +1,372 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/HouzuoGuo/tiedot/db"
+	"goji.io/pat"
+	"golang.org/x/net/context"
+)
+
+// ReadIndexesHandler handles: GET /db/:collection/index.
+// Returns the paths of all indexes of the collection.
+func (d *DBController) ReadIndexesHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	coll := d.UseCollection(ctx, w)
+	if coll == nil {
+		return
+	}
+
+	indexes := [][]string{}
+	indexes = append(indexes, coll.AllIndexes()...)
+
+	WriteResponse(ctx, w, http.StatusOK, map[string]interface{}{
+		"indexes": indexes,
+	})
+}
+
+// IndexUsage describes an index of a collection and which requests look it up.
+type IndexUsage struct {
+	Path []string `json:"path"`
+	// Filter is an example query param of collection reads answered by the index.
+	Filter string `json:"filter"`
+	// Query is an example Tiedot query using the index.
+	Query map[string]interface{} `json:"query"`
+	// Unique is set if the path is a unique field, whose checks use the index.
+	Unique bool `json:"unique"`
+	// Key is set if the path is the natural key, whose checks use the index.
+	Key bool `json:"key"`
+}
+
+// ListIndexesHandler handles: GET /db/:collection/indexes.
+// Returns every index of the collection with examples of filters and queries
+// using it and whether uniqueness or natural key checks look it up.
+// 'missing' holds the unique fields and the key without an index, checking
+// them scans the whole collection on every write.
+// Nothing is evaluated, use POST /db/:collection/explain to check a query.
+func (d *DBController) ListIndexesHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	collName := pat.Param(ctx, "collection")
+
+	coll := d.UseCollection(ctx, w)
+	if coll == nil {
+		return
+	}
+
+	cfg := d.CollectionConfig(collName)
+	checked := append([][]string{}, cfg.Unique...)
+	if cfg.Key != nil {
+		checked = appendPath(checked, cfg.Key)
+	}
+
+	indexes := []IndexUsage{}
+	for _, path := range coll.AllIndexes() {
+		usage := IndexUsage{
+			Path:   path,
+			Filter: strings.Join(path, ".") + "=value",
+			Query:  Filter{Path: path, Value: "value"}.Query(),
+			Key:    cfg.Key != nil && equalPaths(cfg.Key, path),
+		}
+		for _, unique := range cfg.Unique {
+			if equalPaths(unique, path) {
+				usage.Unique = true
+			}
+		}
+		indexes = append(indexes, usage)
+	}
+
+	missing := [][]string{}
+	for _, path := range checked {
+		if !HasIndex(coll, path) {
+			missing = appendPath(missing, path)
+		}
+	}
+
+	WriteResponse(ctx, w, http.StatusOK, map[string]interface{}{
+		"indexes": indexes,
+		"missing": missing,
+	})
+}
+
+// CreateIndexHandler handles: POST /db/:collection/index.
+// Creates an index on the given path.
+// Payload example:
+//
+//	{
+//	  "path": ["username"]
+//	}
+func (d *DBController) CreateIndexHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	collName := pat.Param(ctx, "collection")
+
+	coll := d.UseCollection(ctx, w)
+	if coll == nil {
+		return
+	}
+
+	path, ok := parseIndexPath(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	if HasIndex(coll, path) {
+		WriteError(ctx, w, http.StatusConflict, CodeConflict, "index "+strings.Join(path, ",")+" already exists")
+		return
+	}
+
+	if err := coll.Index(path); err != nil {
+		WriteError(ctx, w, http.StatusInternalServerError, CodeInternal, "could not create index: "+err.Error())
+		return
+	}
+
+	Logger(ctx).Info("created index", "collection", collName, "path", path)
+
+	WriteResponse(ctx, w, http.StatusCreated, map[string]interface{}{
+		"path": path,
+	})
+}
+
+// DeleteIndexHandler handles: DELETE /db/:collection/index.
+// Removes the index on the given path. Expects the same payload as CreateIndexHandler.
+func (d *DBController) DeleteIndexHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	collName := pat.Param(ctx, "collection")
+
+	coll := d.UseCollection(ctx, w)
+	if coll == nil {
+		return
+	}
+
+	path, ok := parseIndexPath(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	if !HasIndex(coll, path) {
+		WriteError(ctx, w, http.StatusNotFound, CodeNotFound, "index "+strings.Join(path, ",")+" does not exist")
+		return
+	}
+
+	if err := coll.Unindex(path); err != nil {
+		WriteError(ctx, w, http.StatusInternalServerError, CodeInternal, "could not remove index: "+err.Error())
+		return
+	}
+
+	Logger(ctx).Info("removed index", "collection", collName, "path", path)
+
+	WriteResponse(ctx, w, http.StatusOK, map[string]interface{}{
+		"path": path,
+	})
+}
+
+// HasIndex reports whether the collection has an index on the given path.
+func HasIndex(coll *db.Col, path []string) bool {
+	for _, idx := range coll.AllIndexes() {
+		if equalPaths(idx, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// equalPaths reports whether both paths contain the same segments.
+func equalPaths(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// parseIndexPath parses the index path from the request body.
+// On failure the error response is written and false is returned.
+func parseIndexPath(ctx context.Context, w http.ResponseWriter, r *http.Request) ([]string, bool) {
+	js, err := ParsePostJSON(r)
+	if err != nil {
+		WriteError(ctx, w, http.StatusBadRequest, CodeInvalidJSON, "request body does not contain valid json: "+err.Error())
+		return nil, false
+	}
+
+	raw, ok := js["path"].([]interface{})
+	if !ok || len(raw) == 0 {
+		WriteError(ctx, w, http.StatusBadRequest, CodeBadRequest, "path must be a non-empty array of strings")
+		return nil, false
+	}
+
+	path := make([]string, len(raw))
+	for i, p := range raw {
+		s, ok := p.(string)
+		if !ok || s == "" {
+			WriteError(ctx, w, http.StatusBadRequest, CodeBadRequest, "path must be a non-empty array of strings")
+			return nil, false
+		}
+		path[i] = s
+	}
+
+	return path, true
+}
+
+// QueryPlan describes how Tiedot evaluates a query on a collection.
+type QueryPlan struct {
+	// Used holds the indexed paths the query looks up.
+	Used [][]string `json:"used"`
+	// Missing holds the paths the query looks up without an index. Tiedot rejects such queries.
+	Missing [][]string `json:"missing"`
+	// FullScan is set if the query has to read every document, which only 'all' does.
+	FullScan bool `json:"fullScan"`
+}
+
+// ExplainQuery reports which indexes the Tiedot query uses without evaluating it.
+// It follows the rules of Tiedot's EvalQuery.
+func ExplainQuery(coll *db.Col, query interface{}) (QueryPlan, error) {
+	plan := QueryPlan{Used: [][]string{}, Missing: [][]string{}}
+	err := plan.explain(coll, query)
+	return plan, err
+}
+
+// explain adds the lookups of the query to the plan.
+func (p *QueryPlan) explain(coll *db.Col, query interface{}) error {
+	switch q := query.(type) {
+	case string:
+		if q == "all" {
+			p.FullScan = true
+			return nil
+		}
+		// Any other string is a single document id, which needs no index.
+		if _, err := strconv.ParseInt(q, 10, 64); err != nil {
+			return &QueryError{Err: fmt.Errorf("unknown query '%s', expected 'all' or a document id", q)}
+		}
+		return nil
+	case float64:
+		// Tiedot accepts numbers but, unlike id strings, they match no document.
+		return nil
+	case []interface{}:
+		// Union of the sub-queries.
+		return p.explainAll(coll, q)
+	case map[string]interface{}:
+		// Tiedot checks the operations in this order and only uses the first one.
+		if _, ok := q["eq"]; ok {
+			return p.lookup(coll, q["in"])
+		}
+		if path, ok := q["has"]; ok {
+			// Existence checks iterate the index of the path.
+			return p.lookup(coll, path)
+		}
+		for _, op := range []string{"n", "c"} {
+			// Intersection and symmetric difference of the sub-queries.
+			if subs, ok := q[op]; ok {
+				list, ok := subs.([]interface{})
+				if !ok {
+					return &QueryError{Err: fmt.Errorf("'%s' needs an array of sub-queries, got %v", op, subs)}
+				}
+				return p.explainAll(coll, list)
+			}
+		}
+		_, rangeFrom := q["int-from"]
+		_, rangeFromSpace := q["int from"]
+		if rangeFrom || rangeFromSpace {
+			return p.lookup(coll, q["in"])
+		}
+		return &QueryError{Err: fmt.Errorf("query %v does not contain any operation", q)}
+	default:
+		return &QueryError{Err: fmt.Errorf("unknown query %v", q)}
+	}
+}
+
+// explainAll adds the lookups of all sub-queries to the plan.
+func (p *QueryPlan) explainAll(coll *db.Col, queries []interface{}) error {
+	for _, sub := range queries {
+		if err := p.explain(coll, sub); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lookup adds the index lookup of the path given in a query to the plan.
+func (p *QueryPlan) lookup(coll *db.Col, v interface{}) error {
+	path, ok := queryPath(v)
+	if !ok {
+		return &QueryError{Err: fmt.Errorf("query needs a path array, got %v", v)}
+	}
+	if HasIndex(coll, path) {
+		p.Used = appendPath(p.Used, path)
+	} else {
+		p.Missing = appendPath(p.Missing, path)
+	}
+	return nil
+}
+
+// queryPath converts the path of a query to a path. Like Tiedot every
+// segment is converted to its string representation.
+func queryPath(v interface{}) ([]string, bool) {
+	raw, ok := v.([]interface{})
+	if !ok {
+		return nil, false
+	}
+
+	path := make([]string, len(raw))
+	for i, p := range raw {
+		path[i] = fmt.Sprint(p)
+	}
+	return path, true
+}
+
+// appendPath appends the path to paths unless it is already contained.
+func appendPath(paths [][]string, path []string) [][]string {
+	for _, p := range paths {
+		if equalPaths(p, path) {
+			return paths
+		}
+	}
+	return append(paths, path)
+}
+
+// ExplainQueryHandler handles: POST /db/:collection/explain.
+// Reports which indexes the Tiedot query in the payload would use, which
+// looked up paths have no index and whether all documents have to be scanned.
+// The query is not evaluated, so this is cheap even for large collections.
+// Payload example:
+//
+//	{
+//	  "query": {"eq": "JohnAppleseed", "in": ["username"]}
+//	}
+func (d *DBController) ExplainQueryHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	coll := d.UseCollection(ctx, w)
+	if coll == nil {
+		return
+	}
+
+	// Parse JSON object from POST parameter.
+	d.LimitBody(w, r)
+	js, err := ParsePostJSON(r)
+	if err != nil {
+		WriteBodyError(ctx, w, err)
+		return
+	}
+
+	query, ok := js["query"]
+	if !ok {
+		WriteError(ctx, w, http.StatusBadRequest, CodeBadRequest, "request body does not contain a query")
+		return
+	}
+
+	plan, err := ExplainQuery(coll, query)
+	if err != nil {
+		WriteError(ctx, w, http.StatusBadRequest, CodeInvalidQuery, err.Error())
+		return
+	}
+
+	indexes := [][]string{}
+	indexes = append(indexes, coll.AllIndexes()...)
+
+	WriteResponse(ctx, w, http.StatusOK, map[string]interface{}{
+		"indexes":  indexes,
+		"used":     plan.Used,
+		"missing":  plan.Missing,
+		"fullScan": plan.FullScan,
+	})
+}
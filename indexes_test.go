@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/HouzuoGuo/tiedot/db"
+)
+
+func TestExplainQuery(t *testing.T) {
+	d := newTestController(t)
+	if err := d.DB.Create("books"); err != nil {
+		t.Fatal(err)
+	}
+	coll := d.DB.Use("books")
+	if err := coll.Index([]string{"name"}); err != nil {
+		t.Fatal(err)
+	}
+
+	name := [][]string{{"name"}}
+	year := [][]string{{"year"}}
+	none := [][]string{}
+
+	tests := []struct {
+		name     string
+		query    string
+		wantErr  bool
+		used     [][]string
+		missing  [][]string
+		fullScan bool
+	}{
+		{name: "eq indexed", query: `{"eq": "Dune", "in": ["name"]}`, used: name, missing: none},
+		{name: "eq unindexed", query: `{"eq": 1965, "in": ["year"]}`, used: none, missing: year},
+		{name: "has indexed", query: `{"has": ["name"]}`, used: name, missing: none},
+		{name: "has unindexed", query: `{"has": ["year"]}`, used: none, missing: year},
+		{name: "int range", query: `{"int-from": 1, "int-to": 3, "in": ["year"]}`, used: none, missing: year},
+		{name: "intersection", query: `{"n": [{"eq": "Dune", "in": ["name"]}, {"has": ["year"]}]}`, used: name, missing: year},
+		{name: "symmetric difference", query: `{"c": [{"eq": "Dune", "in": ["name"]}, {"eq": "Emma", "in": ["name"]}]}`, used: name, missing: none},
+		{name: "union", query: `["all", {"eq": "Dune", "in": ["name"]}]`, used: name, missing: none, fullScan: true},
+		{name: "all", query: `"all"`, used: none, missing: none, fullScan: true},
+		{name: "id string", query: `"123"`, used: none, missing: none},
+		{name: "id number", query: `123`, used: none, missing: none},
+		{name: "unknown string", query: `"some"`, wantErr: true},
+		{name: "no operation", query: `{"in": ["name"]}`, wantErr: true},
+		{name: "eq without path", query: `{"eq": "Dune"}`, wantErr: true},
+		{name: "c without array", query: `{"c": {"eq": "Dune", "in": ["name"]}}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var query interface{}
+			if err := json.Unmarshal([]byte(tt.query), &query); err != nil {
+				t.Fatal(err)
+			}
+
+			plan, err := ExplainQuery(coll, query)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ExplainQuery() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			// Tiedot must agree on whether the query can be run.
+			tiedotErr := db.EvalQuery(query, coll, &map[int]struct{}{})
+			if wantFail := tt.wantErr || len(tt.missing) > 0; (tiedotErr != nil) != wantFail {
+				t.Errorf("EvalQuery() error = %v, want failure %v", tiedotErr, wantFail)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if !reflect.DeepEqual(plan.Used, tt.used) || !reflect.DeepEqual(plan.Missing, tt.missing) || plan.FullScan != tt.fullScan {
+				t.Errorf("ExplainQuery() = %+v, want used %v, missing %v, fullScan %v", plan, tt.used, tt.missing, tt.fullScan)
+			}
+		})
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// MediaTypeJSONPatch is the media type of JSON patch (RFC 6902) request bodies.
+const MediaTypeJSONPatch = "application/json-patch+json"
+
+// JSONPatchOp is a single operation of a JSON patch.
+type JSONPatchOp struct {
+	Op   string `json:"op"`
+	Path string `json:"path"`
+	From string `json:"from"`
+	// Value is nil if the operation has no value, so null values can be told apart.
+	Value json.RawMessage `json:"value"`
+}
+
+// JSONPatchError describes an operation of a JSON patch that could not be applied.
+type JSONPatchError struct {
+	// Index is the position of the operation in the patch.
+	Index int
+	Msg   string
+	// TestFailed is set if a test operation did not match, else the patch is invalid.
+	TestFailed bool
+}
+
+func (e *JSONPatchError) Error() string {
+	return "operation " + strconv.Itoa(e.Index) + ": " + e.Msg
+}
+
+// IsJSONPatch reports whether the request body is a JSON patch.
+func IsJSONPatch(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == MediaTypeJSONPatch
+}
+
+// ParseJSONPatch parses the request body as array of JSON patch operations.
+func ParseJSONPatch(r *http.Request) ([]JSONPatchOp, error) {
+	ops := []JSONPatchOp{}
+
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&ops)
+
+	return ops, err
+}
+
+// WriteJSONPatchError writes 409 for a failed test operation and 422 for an invalid patch.
+func WriteJSONPatchError(ctx context.Context, w http.ResponseWriter, err error) {
+	var patchErr *JSONPatchError
+	if errors.As(err, &patchErr) && patchErr.TestFailed {
+		WriteError(ctx, w, http.StatusConflict, CodeTestFailed, err.Error())
+		return
+	}
+	WriteError(ctx, w, http.StatusUnprocessableEntity, CodeInvalidPatch, err.Error())
+}
+
+// ApplyJSONPatch applies the operations to the document with the given id and
+// returns the patched document. Timestamps and the id are updated afterwards.
+// doc may be modified even if an error is returned and must be discarded then.
+func (d *DBController) ApplyJSONPatch(collName string, id int, doc map[string]interface{}, ops []JSONPatchOp) (map[string]interface{}, error) {
+	existing := map[string]interface{}{}
+	for k, v := range doc {
+		existing[k] = v
+	}
+
+	var root interface{} = doc
+	for i, op := range ops {
+		var err error
+		if root, err = applyJSONPatchOp(root, op); err != nil {
+			var patchErr *JSONPatchError
+			if errors.As(err, &patchErr) {
+				patchErr.Index = i
+				return nil, patchErr
+			}
+			return nil, &JSONPatchError{Index: i, Msg: err.Error()}
+		}
+	}
+
+	patched, ok := root.(map[string]interface{})
+	if !ok {
+		return nil, &JSONPatchError{Index: len(ops) - 1, Msg: "the patched document is not a json object"}
+	}
+
+	d.StampUpdated(collName, patched, existing)
+
+	// Always replace id with correct id == avoid user errors.
+	d.SetDocumentID(patched, id)
+	return patched, nil
+}
+
+// applyJSONPatchOp applies a single operation to root and returns the new root.
+func applyJSONPatchOp(root interface{}, op JSONPatchOp) (interface{}, error) {
+	path, err := parseJSONPointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, fmt.Errorf("%s requires a value", op.Op)
+		}
+		if err := json.Unmarshal(op.Value, &value); err != nil {
+			return nil, err
+		}
+	}
+
+	switch op.Op {
+	case "add":
+		return patchAdd(root, path, value)
+	case "remove":
+		return patchRemove(root, path)
+	case "replace":
+		if len(path) == 0 {
+			return value, nil
+		}
+		if root, err = patchRemove(root, path); err != nil {
+			return nil, err
+		}
+		return patchAdd(root, path, value)
+	case "test":
+		current, err := patchGet(root, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(current, value) {
+			return nil, &JSONPatchError{Msg: "value at '" + op.Path + "' does not match", TestFailed: true}
+		}
+		return root, nil
+	case "move", "copy":
+		from, err := parseJSONPointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		value, err := patchGet(root, from)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "copy" {
+			return patchAdd(root, path, copyValue(value))
+		}
+		if op.Path != op.From && strings.HasPrefix(op.Path, op.From+"/") {
+			return nil, errors.New("cannot move '" + op.From + "' into itself")
+		}
+		if root, err = patchRemove(root, from); err != nil {
+			return nil, err
+		}
+		return patchAdd(root, path, value)
+	default:
+		return nil, errors.New("unknown op '" + op.Op + "'")
+	}
+}
+
+// parseJSONPointer splits a JSON pointer (RFC 6901) into its unescaped tokens.
+// The empty pointer refers to the whole document.
+func parseJSONPointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, errors.New("path '" + pointer + "' must start with /")
+	}
+
+	unescape := strings.NewReplacer("~1", "/", "~0", "~")
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = unescape.Replace(t)
+	}
+	return tokens, nil
+}
+
+// arrayIndex parses the token as index into an array of length n.
+// With end the index n is valid too.
+func arrayIndex(token string, n int, end bool) (int, error) {
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i > n || (i == n && !end) || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, errors.New("invalid array index '" + token + "'")
+	}
+	return i, nil
+}
+
+// patchGet returns the value at path.
+func patchGet(node interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			v, ok := n[token]
+			if !ok {
+				return nil, errors.New("field '" + token + "' does not exist")
+			}
+			node = v
+		case []interface{}:
+			i, err := arrayIndex(token, len(n), false)
+			if err != nil {
+				return nil, err
+			}
+			node = n[i]
+		default:
+			return nil, errors.New("cannot access '" + token + "' of a non-container value")
+		}
+	}
+	return node, nil
+}
+
+// patchParent calls fn with the container holding the last token of path and
+// stores the container returned by fn in its parent. Arrays change on insert and
+// removal, so every level returns its possibly new value.
+func patchParent(node interface{}, path []string, fn func(container interface{}, token string) (interface{}, error)) (interface{}, error) {
+	if len(path) == 1 {
+		return fn(node, path[0])
+	}
+
+	switch n := node.(type) {
+	case map[string]interface{}:
+		child, ok := n[path[0]]
+		if !ok {
+			return nil, errors.New("field '" + path[0] + "' does not exist")
+		}
+		child, err := patchParent(child, path[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		n[path[0]] = child
+		return n, nil
+	case []interface{}:
+		i, err := arrayIndex(path[0], len(n), false)
+		if err != nil {
+			return nil, err
+		}
+		child, err := patchParent(n[i], path[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		n[i] = child
+		return n, nil
+	default:
+		return nil, errors.New("cannot access '" + path[0] + "' of a non-container value")
+	}
+}
+
+// patchAdd adds the value at path. Existing fields are replaced and array
+// elements are inserted; the token '-' appends to an array.
+func patchAdd(root interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	return patchParent(root, path, func(container interface{}, token string) (interface{}, error) {
+		switch c := container.(type) {
+		case map[string]interface{}:
+			c[token] = value
+			return c, nil
+		case []interface{}:
+			if token == "-" {
+				return append(c, value), nil
+			}
+			i, err := arrayIndex(token, len(c), true)
+			if err != nil {
+				return nil, err
+			}
+			c = append(c, nil)
+			copy(c[i+1:], c[i:])
+			c[i] = value
+			return c, nil
+		default:
+			return nil, errors.New("cannot add '" + token + "' to a non-container value")
+		}
+	})
+}
+
+// patchRemove removes the value at path, which must exist.
+func patchRemove(root interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, errors.New("cannot remove the whole document")
+	}
+
+	return patchParent(root, path, func(container interface{}, token string) (interface{}, error) {
+		switch c := container.(type) {
+		case map[string]interface{}:
+			if _, ok := c[token]; !ok {
+				return nil, errors.New("field '" + token + "' does not exist")
+			}
+			delete(c, token)
+			return c, nil
+		case []interface{}:
+			i, err := arrayIndex(token, len(c), false)
+			if err != nil {
+				return nil, err
+			}
+			return append(c[:i], c[i+1:]...), nil
+		default:
+			return nil, errors.New("cannot remove '" + token + "' from a non-container value")
+		}
+	})
+}
+
+// copyValue returns a deep copy of a decoded JSON value.
+func copyValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			c[k] = copyValue(e)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(v))
+		for i, e := range v {
+			c[i] = copyValue(e)
+		}
+		return c
+	default:
+		return v
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestPatchDocumentJSONPatch(t *testing.T) {
+	const doc = `{"title": "Dune", "tags": ["sf", "classic"], "author": {"name": "Herbert"}}`
+
+	tests := []struct {
+		name  string
+		patch string
+		code  int
+		want  map[string]interface{}
+	}{
+		{
+			name: "operations",
+			patch: `[
+				{"op": "test", "path": "/title", "value": "Dune"},
+				{"op": "add", "path": "/tags/1", "value": "desert"},
+				{"op": "remove", "path": "/tags/2"},
+				{"op": "replace", "path": "/author/name", "value": "Frank Herbert"},
+				{"op": "copy", "from": "/title", "path": "/series"},
+				{"op": "move", "from": "/author", "path": "/writer"}
+			]`,
+			code: http.StatusOK,
+			want: map[string]interface{}{
+				"title":  "Dune",
+				"series": "Dune",
+				"tags":   []interface{}{"sf", "desert"},
+				"writer": map[string]interface{}{"name": "Frank Herbert"},
+			},
+		},
+		{
+			name: "failed test",
+			patch: `[
+				{"op": "remove", "path": "/tags"},
+				{"op": "test", "path": "/title", "value": "Emma"}
+			]`,
+			code: http.StatusConflict,
+		},
+		{
+			name: "missing path",
+			patch: `[
+				{"op": "add", "path": "/year", "value": 1965},
+				{"op": "remove", "path": "/publisher"}
+			]`,
+			code: http.StatusUnprocessableEntity,
+		},
+		{
+			name:  "not an object",
+			patch: `[{"op": "replace", "path": "", "value": [1, 2]}]`,
+			code:  http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestController(t)
+			if err := d.DB.Create("books"); err != nil {
+				t.Fatal(err)
+			}
+			id := createDocument(t, d, "books", doc)
+
+			r := newRequest("PATCH", "/db/books/"+id, tt.patch)
+			r.Header.Set("Content-Type", MediaTypeJSONPatch)
+			if w := serveRequest(t, d, r); w.Code != tt.code {
+				t.Fatalf("status = %d, want %d", w.Code, tt.code)
+			}
+
+			// Failed patches must not store any of their operations.
+			want := tt.want
+			if want == nil {
+				want = map[string]interface{}{
+					"title":  "Dune",
+					"tags":   []interface{}{"sf", "classic"},
+					"author": map[string]interface{}{"name": "Herbert"},
+				}
+			}
+			got := decode(t, serve(t, d, "GET", "/db/books/"+id, ""))
+			delete(got, d.IDField)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("document = %v, want %v", got, want)
+			}
+		})
+	}
+}
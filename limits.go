@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// CheckStructure returns violations for values nested deeper than MaxDepth and
+// objects with more than MaxKeys keys. The document itself is at depth 1.
+func (d *DBController) CheckStructure(doc map[string]interface{}) []string {
+	violations := []string{}
+	if d.MaxDepth > 0 || d.MaxKeys > 0 {
+		d.checkStructure(doc, "", 1, &violations)
+	}
+	return violations
+}
+
+// checkStructure checks the value at path and depth and descends into objects and arrays.
+// Values exceeding MaxDepth are reported without descending further.
+func (d *DBController) checkStructure(v interface{}, path string, depth int, violations *[]string) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if d.MaxDepth > 0 && depth > d.MaxDepth {
+			*violations = append(*violations, fmt.Sprintf("%s: exceeds the maximum nesting depth of %d", fieldPath(path), d.MaxDepth))
+			return
+		}
+		if d.MaxKeys > 0 && len(v) > d.MaxKeys {
+			*violations = append(*violations, fmt.Sprintf("%s: has %d keys, at most %d are allowed", fieldPath(path), len(v), d.MaxKeys))
+			return
+		}
+
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			d.checkStructure(v[k], joinPath(path, k), depth+1, violations)
+		}
+	case []interface{}:
+		if d.MaxDepth > 0 && depth > d.MaxDepth {
+			*violations = append(*violations, fmt.Sprintf("%s: exceeds the maximum nesting depth of %d", fieldPath(path), d.MaxDepth))
+			return
+		}
+
+		for i, e := range v {
+			d.checkStructure(e, fmt.Sprintf("%s[%d]", fieldPath(path), i), depth+1, violations)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+)
+
+// DocumentLocks serializes read-modify-write cycles on single documents or whole collections.
+// Tiedot is thread-safe per operation, but a read followed by an update of
+// the same document can lose concurrent changes without additional locking.
+// The zero value is ready to use.
+type DocumentLocks struct {
+	mu    sync.Mutex
+	locks map[string]*documentLock
+}
+
+// documentLock is the lock of a single document. refs counts the holders and
+// waiters so the lock can be dropped once nobody uses it anymore.
+type documentLock struct {
+	sync.Mutex
+	refs int
+}
+
+// Lock locks the document with the given id and returns the function to unlock it.
+func (l *DocumentLocks) Lock(collName string, id int) (unlock func()) {
+	return l.lock(collName + "/" + strconv.Itoa(id))
+}
+
+// LockCollection locks the collection as a whole and returns the function to unlock it.
+// It does not exclude holders of document locks of the collection.
+func (l *DocumentLocks) LockCollection(collName string) (unlock func()) {
+	return l.lock(collName)
+}
+
+// lock locks the given key and returns the function to unlock it.
+func (l *DocumentLocks) lock(key string) (unlock func()) {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = map[string]*documentLock{}
+	}
+	lock, ok := l.locks[key]
+	if !ok {
+		lock = &documentLock{}
+		l.locks[key] = lock
+	}
+	lock.refs++
+	l.mu.Unlock()
+
+	lock.Lock()
+
+	return func() {
+		lock.Unlock()
+
+		l.mu.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(l.locks, key)
+		}
+		l.mu.Unlock()
+	}
+}
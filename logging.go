@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+
+	"goji.io"
+	"golang.org/x/net/context"
+)
+
+type contextKey int
+
+// RequestIDHeader is the header carrying the request id in requests and responses.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength limits the length of request ids accepted from clients.
+const maxRequestIDLength = 128
+
+const (
+	loggerKey contextKey = iota
+	requestIDKey
+	responseFormatKey
+	prettyKey
+)
+
+// NewRequestID returns a random hex encoded id to tag a request with.
+func NewRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// RequestID returns the id of the request the context belongs to.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// Logger returns the request-scoped logger stored in the context.
+// If there is none the default logger is returned.
+func Logger(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+// validRequestID reports whether a request id sent by a client may be used.
+// Only short ids of printable ASCII characters are accepted so they cannot
+// mess up logs or response headers.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// RequestLogger is a middleware that tags every request with a request id
+// and stores a logger carrying the id, method and path in the context.
+// The id is taken from the X-Request-ID header if the client sent a valid one
+// and generated otherwise. It is echoed in the X-Request-ID response header.
+func RequestLogger(h goji.Handler) goji.Handler {
+	return goji.HandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = NewRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		l := slog.Default().With(
+			"request_id", id,
+			"method", r.Method,
+			"path", r.URL.Path,
+		)
+
+		ctx = context.WithValue(ctx, requestIDKey, id)
+		ctx = context.WithValue(ctx, loggerKey, l)
+
+		l.Debug("handling request")
+		h.ServeHTTPC(ctx, w, r)
+	})
+}
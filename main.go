@@ -2,35 +2,152 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"log/slog"
+	"math/rand"
+	"mime"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/HouzuoGuo/tiedot/db"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/vmihailenco/msgpack/v5"
 	"goji.io"
 	"goji.io/pat"
 	"golang.org/x/net/context"
 )
 
+// Defaults for the storage folder and collections config file.
+// Both can be overridden with command line flags.
 const (
 	DBFolder          = "storage"
 	CollectionsConfig = "collections.conf"
 )
 
-// WriteResponse writes the resp interface with assigned http status code as JSON response
-// to the given http.ResponseWriter.
+// DefaultNamePattern is the default pattern for collection names. Only a-z,A-Z allowed.
+const DefaultNamePattern = "[a-zA-Z]*"
+
+// CollectionNameRegexp matches valid collection names.
+// It is replaced on startup if the -namepattern flag is given.
+var CollectionNameRegexp = MustCompileNamePattern(DefaultNamePattern)
+
+// Error codes of error responses. Clients can branch on them instead of the message.
+const (
+	CodeBadRequest           = "bad_request"
+	CodeBadID                = "bad_id"
+	CodeInvalidJSON          = "invalid_json"
+	CodeInvalidName          = "invalid_name"
+	CodeInvalidQuery         = "invalid_query"
+	CodeBodyTooLarge         = "body_too_large"
+	CodeSchemaViolation      = "schema_violation"
+	CodeNotFound             = "not_found"
+	CodeCollectionNotFound   = "collection_not_found"
+	CodeDocumentNotFound     = "document_not_found"
+	CodeConflict             = "conflict"
+	CodePreconditionFailed   = "precondition_failed"
+	CodePreconditionRequired = "precondition_required"
+	CodeRateLimited          = "rate_limited"
+	CodeTooManyResults       = "too_many_results"
+	CodeTimeout              = "timeout"
+	CodeReadOnly             = "read_only"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodeCollectionLimit      = "collection_limit"
+	CodeUnauthorized         = "unauthorized"
+	CodeInvalidPatch         = "invalid_patch"
+	CodeTestFailed           = "test_failed"
+	CodeNotReady             = "not_ready"
+	CodeInternal             = "internal"
+)
+
+// NewError returns the error object used in error responses.
+func NewError(code, message string) map[string]interface{} {
+	return map[string]interface{}{
+		"code":    code,
+		"message": message,
+	}
+}
+
+// WriteError writes an error response with assigned http status code of the form:
+//
+//	{"error": {"code": "collection_not_found", "message": "..."}}
+func WriteError(ctx context.Context, w http.ResponseWriter, status int, code, message string) {
+	WriteResponse(ctx, w, status, map[string]interface{}{
+		"error": NewError(code, message),
+	})
+}
+
+// MethodNotAllowed returns a handler answering requests with 405 and the
+// given comma separated list of supported methods in the Allow header.
+func MethodNotAllowed(allow string) goji.HandlerFunc {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", allow)
+		WriteError(ctx, w, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method "+r.Method+" is not allowed, use one of: "+allow)
+	}
+}
+
+// WriteResponse writes the resp interface with assigned http status code as response
+// to the given http.ResponseWriter. The response is encoded as MessagePack if it was
+// negotiated via the Accept header and as JSON otherwise.
+// The response is encoded before anything is written, so an encoding failure
+// results in a 500 error instead of a truncated body.
 func WriteResponse(ctx context.Context, w http.ResponseWriter, status int, resp interface{}) {
-	w.Header().Set("Content-Type", "application/json")
+	format := ResponseFormat(ctx)
+
+	pretty := PrettyResponse(ctx)
+
+	body, err := encodeResponse(format, pretty, resp)
+	if err != nil {
+		Logger(ctx).Error("could not encode response", "format", format, "error", err)
+
+		status = http.StatusInternalServerError
+		body, err = encodeResponse(format, pretty, map[string]interface{}{
+			"error": NewError(CodeInternal, "could not encode response"),
+		})
+		if err != nil {
+			w.WriteHeader(status)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", format)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
 	w.WriteHeader(status)
+	if _, err := w.Write(body); err != nil {
+		Logger(ctx).Error("could not write response", "format", format, "error", err)
+	}
+}
 
-	if err := json.NewEncoder(w).Encode(resp); err != nil {
-		fmt.Println("Error writing json response:", err.Error())
+// encodeResponse encodes resp in the given format.
+// If pretty is set JSON is indented.
+func encodeResponse(format string, pretty bool, resp interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+
+	var err error
+	if format == MediaTypeMsgpack {
+		err = msgpack.NewEncoder(&buf).Encode(resp)
+	} else {
+		enc := json.NewEncoder(&buf)
+		if pretty {
+			enc.SetIndent("", "  ")
+		}
+		err = enc.Encode(resp)
 	}
+	return buf.Bytes(), err
 }
 
 // ParsePostJSON parses the request body from a POST request and
@@ -44,347 +161,1971 @@ func ParsePostJSON(r *http.Request) (map[string]interface{}, error) {
 	return ret, err
 }
 
+// LimitBody limits the request body to the configured maximum size.
+func (d *DBController) LimitBody(w http.ResponseWriter, r *http.Request) {
+	if d.MaxBodySize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, d.MaxBodySize)
+	}
+}
+
+// WriteBodyError writes the error response for a request body that could not be parsed.
+// Bodies exceeding the size limit are answered with 413, all other errors with 400.
+func WriteBodyError(ctx context.Context, w http.ResponseWriter, err error) {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		WriteError(ctx, w, http.StatusRequestEntityTooLarge, CodeBodyTooLarge, "request body exceeds "+strconv.FormatInt(maxErr.Limit, 10)+" bytes")
+		return
+	}
+
+	WriteError(ctx, w, http.StatusBadRequest, CodeInvalidJSON, "request body does not contain valid json: "+err.Error())
+}
+
+// ParseID parses the document id from the path. On failure a 400 response
+// explaining the expected format is written and false is returned.
+func ParseID(ctx context.Context, w http.ResponseWriter, strid string) (int, bool) {
+	id, err := strconv.Atoi(strid)
+	if err != nil || id <= 0 {
+		WriteError(ctx, w, http.StatusBadRequest, CodeBadID, "invalid id '"+strid+"': ids must be positive integers")
+		return 0, false
+	}
+	return id, true
+}
+
+// ParseJSONID parses a document id sent in a request body as JSON number or
+// numeric string. Ids exceed the range float64 holds exactly, so they must be
+// taken from the raw JSON instead of a value decoded into interface{}.
+func ParseJSONID(raw json.RawMessage) (int, bool) {
+	var n json.Number
+	if err := json.Unmarshal(raw, &n); err != nil {
+		return 0, false
+	}
+	id, err := strconv.Atoi(n.String())
+	return id, err == nil && id > 0
+}
+
+// ParsePostJSONArray parses the request body from a POST request and
+// returns the decoded JSON as []interface{}.
+func ParsePostJSONArray(r *http.Request) ([]interface{}, error) {
+	ret := []interface{}{}
+
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&ret)
+
+	return ret, err
+}
+
 // DBController is a helper struct to hold a db instance for handler methods.
 type DBController struct {
 	DB *db.DB
+
+	// MaxBodySize is the maximum size of request bodies in bytes.
+	// 0 means no limit.
+	MaxBodySize int64
+
+	// NumericIDs stores the id field of documents as number instead of string.
+	NumericIDs bool
+
+	// IDField is the name of the field the document id is stored in.
+	// It is always overwritten with the id assigned by the database.
+	// Empty means ids are not stored in documents.
+	IDField string
+
+	// Timestamps enables the server-side created_at and updated_at fields
+	// for all collections.
+	Timestamps bool
+
+	// AutoCreate creates missing collections when a document is created in them.
+	AutoCreate bool
+
+	// MaxCollections is the maximum number of collections that can be created
+	// at runtime. 0 means no limit.
+	MaxCollections int
+
+	// ReadOnly rejects all requests that modify the database.
+	ReadOnly bool
+
+	// RequireIfMatch rejects updates of existing documents without If-Match header.
+	RequireIfMatch bool
+
+	// MaxResults is the maximum number of documents a search may read.
+	// 0 means no limit.
+	MaxResults int
+
+	// SearchTimeout limits the time a search may spend reading documents.
+	// 0 means no limit.
+	SearchTimeout time.Duration
+
+	// DefaultLimit is the limit of collection reads without 'limit' param.
+	// 0 means no limit.
+	DefaultLimit int
+
+	// MaxDepth is the maximum nesting depth of created and updated documents.
+	// 0 means no limit.
+	MaxDepth int
+
+	// MaxKeys is the maximum number of keys of every object in created and updated documents.
+	// 0 means no limit.
+	MaxKeys int
+
+	// Retry controls retries of document writes failing with transient errors.
+	Retry RetryPolicy
+
+	// BasePath is the prefix all routes are served under, e.g. /api/v1.
+	// Empty means routes are served at the root.
+	BasePath string
+
+	// Cache holds recently read documents. nil disables caching.
+	Cache *DocumentCache
+
+	// Collections holds the settings of the configured collections by name.
+	// They are loaded by SetupCollections.
+	Collections map[string]*CollectionConfig
+
+	// Events publishes the changes made through the API to watchers.
+	Events *EventBus
+
+	// locks protects documents during read-modify-write cycles.
+	locks DocumentLocks
+
+	// createMu serializes the creation of collections at runtime.
+	createMu sync.Mutex
 }
 
 // NewDBController creates an instance of DBController with a pointer to the given database.
 // This is thread-safe thanks to Tiedot.
 func NewDBController(db *db.DB) *DBController {
 	c := &DBController{
-		DB: db,
+		DB:          db,
+		IDField:     "id",
+		Collections: map[string]*CollectionConfig{},
+		Events:      NewEventBus(),
 	}
 	return c
 }
 
+// MaxNumericID is the largest id used with NumericIDs. JSON clients decode
+// numbers as float64, which holds integers only up to 2^53 exactly.
+const MaxNumericID = 1<<53 - 1
+
+// DocumentID returns the value stored in the id field of the document with the given id.
+// This is a JSON number if NumericIDs is set and a string otherwise.
+// With NumericIDs new ids never exceed MaxNumericID.
+func (d *DBController) DocumentID(id int) interface{} {
+	if d.NumericIDs {
+		return id
+	}
+	return strconv.Itoa(id)
+}
+
+// ListenUnix listens on the Unix domain socket at path. A stale socket file left
+// behind by a crashed server is removed first, other files are never removed.
+// The socket file is removed again when the listener is closed.
+func ListenUnix(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is used by another server", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen("unix", path)
+}
+
+// CompileNamePattern compiles the pattern for collection names.
+// The pattern always has to match the whole name.
+func CompileNamePattern(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + pattern + ")$")
+}
+
+// MustCompileNamePattern is like CompileNamePattern but panics if the pattern is invalid.
+func MustCompileNamePattern(pattern string) *regexp.Regexp {
+	re, err := CompileNamePattern(pattern)
+	if err != nil {
+		panic(err)
+	}
+	return re
+}
+
+// ValidCollectionName reports whether the name can be used for a collection.
+func ValidCollectionName(name string) bool {
+	// Names are used as folder names by Tiedot, so never allow leaving the database folder.
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return false
+	}
+	return CollectionNameRegexp.MatchString(name)
+}
+
+// CollectionParam returns the 'collection' path param.
+// If it is not a valid collection name a 400 response is written and false is returned.
+func CollectionParam(ctx context.Context, w http.ResponseWriter) (string, bool) {
+	collName := pat.Param(ctx, "collection")
+
+	if !ValidCollectionName(collName) {
+		WriteError(ctx, w, http.StatusBadRequest, CodeInvalidName, "collection name has invalid characters")
+		return "", false
+	}
+	return collName, true
+}
+
+// SetDocumentID stores the id in the IDField of the document.
+// Any value sent by the client is overwritten to avoid user errors.
+func (d *DBController) SetDocumentID(doc map[string]interface{}, id int) {
+	if d.IDField != "" {
+		doc[d.IDField] = d.DocumentID(id)
+	}
+}
+
+// UseCollection returns the collection named by the 'collection' path param.
+// If the name is invalid a 400 response is written, if the collection does
+// not exist a 404 response. In both cases nil is returned.
+func (d *DBController) UseCollection(ctx context.Context, w http.ResponseWriter) *db.Col {
+	collName, ok := CollectionParam(ctx, w)
+	if !ok {
+		return nil
+	}
+
+	coll := d.DB.Use(collName)
+	if coll == nil {
+		WriteError(ctx, w, http.StatusNotFound, CodeCollectionNotFound, "collection "+collName+" does not exist")
+		return nil
+	}
+	return coll
+}
+
+// createMissingCollection creates the collection of a document that is about
+// to be inserted, if it does not exist yet, and returns it.
+func (d *DBController) createMissingCollection(ctx context.Context, collName string) (*db.Col, error) {
+	created, err := d.CreateCollection(collName)
+	if err != nil {
+		return nil, err
+	}
+	if created {
+		Logger(ctx).Info("created collection", "collection", collName)
+	}
+
+	coll := d.DB.Use(collName)
+	if coll == nil {
+		// Dropped again by a concurrent request.
+		return nil, errors.New("collection " + collName + " does not exist")
+	}
+	return coll, nil
+}
+
+// ErrCollectionLimit is returned when creating a collection would exceed MaxCollections.
+var ErrCollectionLimit = errors.New("maximum number of collections reached")
+
+// CreateCollection creates the collection unless it already exists and reports
+// whether it was created. Concurrent calls for a new collection create it only
+// once, the others see it as existing instead of failing.
+// ErrCollectionLimit is returned if the collection would exceed MaxCollections.
+func (d *DBController) CreateCollection(collName string) (created bool, err error) {
+	d.createMu.Lock()
+	defer d.createMu.Unlock()
+
+	if d.DB.Use(collName) != nil {
+		return false, nil
+	}
+	if d.CollectionLimitReached() {
+		return false, ErrCollectionLimit
+	}
+	if err := d.DB.Create(collName); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// CollectionLimitReached reports whether creating another collection would exceed MaxCollections.
+func (d *DBController) CollectionLimitReached() bool {
+	return d.MaxCollections > 0 && len(d.DB.AllCols()) >= d.MaxCollections
+}
+
+// WriteCollectionLimitError writes the error response for exceeding MaxCollections.
+func WriteCollectionLimitError(ctx context.Context, w http.ResponseWriter) {
+	WriteError(ctx, w, http.StatusForbidden, CodeCollectionLimit, "maximum number of collections reached")
+}
+
+// SetupOptions control how SetupCollections treats the config file.
+type SetupOptions struct {
+	// SkipInvalid skips lines that fail and returns all errors joined after
+	// the whole file was processed. Otherwise the first error aborts the setup.
+	SkipInvalid bool
+	// Prune drops all collections in the database that are not listed in the
+	// config file. Nothing is dropped if the config file is missing or had errors.
+	Prune bool
+}
+
 // SetupCollections reads all collection names from the config file
 // and creates the collections in the database if they don't exist yet.
+// A line may reference a schema file for the collection, e.g. 'users:users.schema.json',
+// followed by options (see CollectionConfig.ParseOptions), e.g. 'users timestamps'.
+// Relative schema paths are resolved against the directory of the config file.
+// Blank lines and lines starting with '#' are ignored. Only the first line of a
+// collection is used, later duplicates are skipped with a warning.
 // This should be run at startup.
-func (d *DBController) SetupCollections(cfgFilePath string) {
-	fmt.Println("Reading collections from file and creating them in DB.")
-	// Read collections config file. Every line contains one collection name.
-	// Only a-z,A-Z allowed.
-	file, err := os.Open(CollectionsConfig)
+func (d *DBController) SetupCollections(cfgFilePath string, opts SetupOptions) error {
+	slog.Info("reading collections from file and creating them in DB", "file", cfgFilePath)
+	// Read collections config file. Every line contains one collection name,
+	// optionally a schema file separated by a colon and options.
+	// Names must match CollectionNameRegexp.
+	file, err := os.Open(cfgFilePath)
+	if os.IsNotExist(err) {
+		slog.Warn("collections config file does not exist, no collections created", "file", cfgFilePath)
+		return nil
+	}
 	if err != nil {
-		panic(err)
+		return err
 	}
 	defer file.Close()
 
 	allCollections := d.DB.AllCols()
-	fmt.Println("Current collections in DB", allCollections)
+	slog.Info("current collections in DB", "collections", allCollections)
+
+	var errs []error
+	configured := map[string]bool{}
+	// firstLine holds the line every collection name was first seen in.
+	firstLine := map[string]int{}
 
 	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		// Check collection name for validity.
-		collName := scanner.Text()
-		collName = strings.TrimSpace(collName)
-		re := regexp.MustCompile("^[a-zA-Z]*$")
+	for line := 1; scanner.Scan(); line++ {
+		collName, schemaFile, options := splitConfigLine(scanner.Text())
+		if collName == "" {
+			continue
+		}
+
+		if first, ok := firstLine[collName]; ok {
+			slog.Warn("skipping duplicate collection in config", "collection", collName, "line", line, "first", first)
+			continue
+		}
+		firstLine[collName] = line
 
-		if !re.MatchString(collName) {
-			panic(fmt.Errorf("Collection name '%s' has invalid characters", collName))
+		// Check collection name for validity.
+		if !ValidCollectionName(collName) {
+			err := fmt.Errorf("line %d: collection name '%s' has invalid characters", line, collName)
+			if !opts.SkipInvalid {
+				return err
+			}
+			slog.Warn("skipping invalid collection name", "collection", collName, "line", line)
+			errs = append(errs, err)
+			continue
 		}
 
+		configured[collName] = true
 		create := true
 
-		// Create collection if it does not exist.
-		for _, c := range allCollections {
-			if collName == c {
-				create = false
-			}
+		// Create collection if it does not exist.
+		for _, c := range allCollections {
+			if collName == c {
+				create = false
+			}
+		}
+
+		if create {
+			slog.Info("creating collection", "collection", collName)
+			if err := d.DB.Create(collName); err != nil {
+				err = fmt.Errorf("line %d: could not create collection '%s': %w", line, collName, err)
+				if !opts.SkipInvalid {
+					return err
+				}
+				slog.Warn("skipping collection that could not be created", "collection", collName, "line", line)
+				errs = append(errs, err)
+				continue
+			}
+
+			allCollections = append(allCollections, collName)
+		} else {
+			slog.Info("skipping collection: already exists", "collection", collName)
+		}
+
+		cfg := &CollectionConfig{}
+		if err := cfg.ParseOptions(options); err != nil {
+			err = fmt.Errorf("line %d: invalid options for collection '%s': %w", line, collName, err)
+			if !opts.SkipInvalid {
+				return err
+			}
+			slog.Warn("skipping invalid collection options", "collection", collName, "line", line)
+			errs = append(errs, err)
+			continue
+		}
+
+		if schemaFile != "" {
+			if !filepath.IsAbs(schemaFile) {
+				schemaFile = filepath.Join(filepath.Dir(cfgFilePath), schemaFile)
+			}
+
+			schema, err := LoadSchema(schemaFile)
+			if err != nil {
+				err = fmt.Errorf("line %d: could not load schema for collection '%s': %w", line, collName, err)
+				if !opts.SkipInvalid {
+					return err
+				}
+				slog.Warn("skipping schema that could not be loaded", "collection", collName, "line", line)
+				errs = append(errs, err)
+				continue
+			}
+
+			slog.Info("loaded schema", "collection", collName, "file", schemaFile)
+			cfg.Schema = schema
+		}
+
+		d.Collections[collName] = cfg
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if opts.Prune {
+		if len(errs) > 0 {
+			slog.Warn("not pruning collections because the config file has errors")
+		} else {
+			d.pruneCollections(configured)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// pruneCollections drops all collections that are not configured.
+func (d *DBController) pruneCollections(configured map[string]bool) {
+	for _, collName := range d.DB.AllCols() {
+		if configured[collName] {
+			continue
+		}
+
+		count := 0
+		if coll := d.DB.Use(collName); coll != nil {
+			count = coll.ApproxDocCount()
+		}
+
+		if err := d.DB.Drop(collName); err != nil {
+			slog.Error("could not prune collection", "collection", collName, "error", err)
+			continue
+		}
+
+		slog.Warn("pruned collection", "collection", collName, "documents", count)
+	}
+}
+
+// CreateDocumentHandler handles: POST /db/:collection.
+// A new arbitrary entry is created in the 'collection'.
+// If the collection does not exist it is created when AutoCreate is enabled,
+// otherwise 404 is returned. It is only created for a valid document and
+// never for dry runs, so rejected requests leave no empty collection behind.
+// If the payload is an array, every object is inserted as in BulkCreateDocumentsHandler.
+// With ?dryRun=true the document is only validated and returned without an id.
+// With If-None-Match: * the document is only created if no document with the same
+// natural key exists, else 412 is returned. This requires a key for the collection.
+func (d *DBController) CreateDocumentHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	// Parse collection type from path.
+	collName, ok := CollectionParam(ctx, w)
+	if !ok {
+		return
+	}
+	Logger(ctx).Debug("creating document", "collection", collName)
+
+	// A missing collection stays nil until the document passed validation.
+	coll := d.DB.Use(collName)
+	if coll == nil && !d.AutoCreate {
+		WriteError(ctx, w, http.StatusNotFound, CodeCollectionNotFound, "collection "+collName+" does not exist")
+		return
+	}
+
+	if !RequireJSON(ctx, w, r) {
+		return
+	}
+
+	// Parse JSON object or array from POST parameter.
+	d.LimitBody(w, r)
+	var body interface{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		WriteBodyError(ctx, w, err)
+		return
+	}
+
+	ifAbsent := r.Header.Get("If-None-Match") == "*"
+
+	var js map[string]interface{}
+	switch v := body.(type) {
+	case map[string]interface{}:
+		js = v
+	case []interface{}:
+		if ifAbsent {
+			WriteError(ctx, w, http.StatusBadRequest, CodeBadRequest, "If-None-Match is only supported for single documents")
+			return
+		}
+		d.insertDocuments(ctx, w, r, collName, coll, v)
+		return
+	default:
+		WriteError(ctx, w, http.StatusBadRequest, CodeInvalidJSON, "request body must be a json object or an array of objects")
+		return
+	}
+
+	d.ApplyDefaults(collName, js)
+	if violations := d.ValidateDocument(collName, js); len(violations) > 0 {
+		WriteSchemaViolations(ctx, w, violations)
+		return
+	}
+
+	if ifAbsent {
+		key := d.CollectionConfig(collName).Key
+		if key == nil {
+			WriteError(ctx, w, http.StatusBadRequest, CodeBadRequest, "If-None-Match requires a key for collection "+collName)
+			return
+		}
+		if v, ok := pathValue(js, key); !ok || v == nil {
+			WriteError(ctx, w, http.StatusBadRequest, CodeBadRequest, "document must contain the key field "+strings.Join(key, "."))
+			return
+		}
+	}
+
+	if IsDryRun(r) {
+		if ifAbsent {
+			if err := d.CheckKeyAbsent(collName, coll, js); err != nil {
+				WriteInsertError(ctx, w, err)
+				return
+			}
+		}
+		if err := d.CheckUnique(collName, coll, -1, js); err != nil {
+			WriteInsertError(ctx, w, err)
+			return
+		}
+		d.StampCreated(collName, js)
+		WriteDryRun(ctx, w, js)
+		return
+	}
+
+	if coll == nil {
+		var err error
+		if coll, err = d.createMissingCollection(ctx, collName); err != nil {
+			WriteInsertError(ctx, w, err)
+			return
+		}
+	}
+
+	// Insert object into collection.
+	insert := d.InsertDocument
+	if ifAbsent {
+		insert = d.InsertDocumentIfAbsent
+	}
+	docID, readBack, err := insert(collName, coll, js)
+	if err != nil {
+		WriteInsertError(ctx, w, err)
+		return
+	}
+
+	Logger(ctx).Info("created document", "collection", collName, "id", docID)
+
+	// Everything done. Return document and where to find it.
+	w.Header().Set("Location", d.BasePath+"/db/"+collName+"/"+strconv.Itoa(docID))
+	WriteResponse(ctx, w, http.StatusCreated, readBack)
+}
+
+// InsertDocument inserts the document into the collection and adds
+// the assigned id and timestamps to it. The stored document is returned.
+// This takes two writes, the insert and an update storing the assigned id,
+// except with NumericIDs.
+// A *UniqueError is returned if the document violates a uniqueness constraint.
+func (d *DBController) InsertDocument(collName string, coll *db.Col, doc map[string]interface{}) (int, map[string]interface{}, error) {
+	return d.insertDocument(collName, coll, doc, false)
+}
+
+// newDocumentID returns a random id up to MaxNumericID that is not used in
+// the collection yet.
+func (d *DBController) newDocumentID(coll *db.Col) int {
+	for {
+		id := rand.Intn(MaxNumericID) + 1
+		if _, err := coll.Read(id); err != nil {
+			return id
+		}
+	}
+}
+
+// InsertDocumentIfAbsent works like InsertDocument but returns ErrDocumentExists
+// if a document with the same natural key exists (see CheckKeyAbsent).
+func (d *DBController) InsertDocumentIfAbsent(collName string, coll *db.Col, doc map[string]interface{}) (int, map[string]interface{}, error) {
+	return d.insertDocument(collName, coll, doc, true)
+}
+
+// insertDocument implements InsertDocument and InsertDocumentIfAbsent.
+func (d *DBController) insertDocument(collName string, coll *db.Col, doc map[string]interface{}, ifAbsent bool) (int, map[string]interface{}, error) {
+	unlock := d.LockUnique(collName)
+	defer unlock()
+
+	if ifAbsent {
+		if err := d.CheckKeyAbsent(collName, coll, doc); err != nil {
+			return 0, nil, err
+		}
+	}
+	if err := d.CheckUnique(collName, coll, -1, doc); err != nil {
+		return 0, nil, err
+	}
+
+	d.StampCreated(collName, doc)
+
+	// Numeric ids must not exceed MaxNumericID, so they are chosen here and the
+	// document is stored with them right away, like creates with PUT.
+	if d.NumericIDs {
+		docID := d.newDocumentID(coll)
+		d.SetDocumentID(doc, docID)
+
+		if err := d.Retry.Do("insert", func() error { return coll.InsertRecovery(docID, doc) }); err != nil {
+			return 0, nil, err
+		}
+
+		d.publish(EventCreated, collName, docID, doc)
+		return docID, doc, nil
+	}
+
+	// Otherwise Tiedot assigns the id. InsertRecovery would save the second
+	// write but skips Tiedot's locks, so the id is stored with an update.
+	var docID int
+	err := d.Retry.Do("insert", func() (err error) {
+		docID, err = coll.Insert(doc)
+		return err
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// The document is not read back since it equals the payload plus id.
+	d.SetDocumentID(doc, docID)
+
+	if err := d.Retry.Do("update", func() error { return coll.Update(docID, doc) }); err != nil {
+		return 0, nil, fmt.Errorf("could not add id to document: %w", err)
+	}
+
+	d.publish(EventCreated, collName, docID, doc)
+	return docID, doc, nil
+}
+
+// UpdateDocument replaces the document with the given id.
+// A *UniqueError is returned if the document violates a uniqueness constraint.
+func (d *DBController) UpdateDocument(collName string, coll *db.Col, id int, doc map[string]interface{}) error {
+	unlock := d.LockUnique(collName)
+	defer unlock()
+
+	if err := d.CheckUnique(collName, coll, id, doc); err != nil {
+		return err
+	}
+	if err := d.Retry.Do("update", func() error { return coll.Update(id, doc) }); err != nil {
+		return err
+	}
+
+	d.publish(EventUpdated, collName, id, doc)
+	return nil
+}
+
+// ReturnPrevious reports whether the client asked for the previous version of
+// an updated document with ?returnPrevious=true.
+func ReturnPrevious(r *http.Request) bool {
+	return r.URL.Query().Get("returnPrevious") == "true"
+}
+
+// UpdateResponse returns the response body for an updated document. With
+// ?returnPrevious=true the document is wrapped together with its version before
+// the update as {"document": ..., "previous": ...}. previous is nil for created documents.
+func UpdateResponse(r *http.Request, doc, previous map[string]interface{}) interface{} {
+	if !ReturnPrevious(r) {
+		return doc
+	}
+	return map[string]interface{}{
+		"document": doc,
+		"previous": previous,
+	}
+}
+
+// ReadCollectionHandler handles: GET /db/:collection.
+// Return all documents contained in the given collection.
+// The result can be paged with the optional query params 'limit' and 'offset',
+// where a missing limit defaults to DefaultLimit unless 'all' is true,
+// ordered by the comma separated top-level fields in 'sort' with 'order' being 'asc' or 'desc' per field (see ParseSort)
+// or else by the default sort of the collection,
+// filtered by equality with all other query params (see ParseFilters),
+// restricted to documents with an updated_at after the RFC3339 timestamp in 'updatedSince'
+// and reduced to the comma separated list of top-level keys in 'fields'.
+func (d *DBController) ReadCollectionHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	collName, ok := CollectionParam(ctx, w)
+	if !ok {
+		return
+	}
+
+	limit, offset, err := ParsePaging(r)
+	if err != nil {
+		WriteError(ctx, w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	if query := r.URL.Query(); !query.Has("limit") && query.Get("all") != "true" {
+		limit = d.DefaultLimit
+	}
+
+	sortKeys, err := ParseSort(r)
+	if err != nil {
+		WriteError(ctx, w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	if sortKeys == nil {
+		sortKeys = d.CollectionConfig(collName).Sort
+	}
+
+	filters, err := ParseFilters(r)
+	if err != nil {
+		WriteError(ctx, w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+
+	var updatedSince time.Time
+	if v := r.URL.Query().Get("updatedSince"); v != "" {
+		if updatedSince, err = time.Parse(time.RFC3339, v); err != nil {
+			WriteError(ctx, w, http.StatusBadRequest, CodeBadRequest, "updatedSince must be a RFC3339 timestamp")
+			return
+		}
+	}
+
+	result, err := d.SearchWithOptions(collName, "all", SearchOptions{
+		Limit:        limit,
+		Offset:       offset,
+		Sort:         sortKeys,
+		Filters:      filters,
+		HideDeleted:  d.hideDeleted(collName, r),
+		UpdatedSince: updatedSince,
+	})
+	if err != nil {
+		WriteSearchError(ctx, w, collName, err)
+		return
+	}
+
+	result["results"] = d.ResponseDocuments(collName, r, result["results"].([]interface{}))
+
+	// Respond with results
+	WriteResponse(ctx, w, http.StatusOK, result)
+}
+
+// ParsePaging parses the 'limit' and 'offset' query params of the request.
+// Missing params default to 0, where a limit of 0 means no limit.
+func ParsePaging(r *http.Request) (limit, offset int, err error) {
+	query := r.URL.Query()
+
+	if v := query.Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 0 {
+			return 0, 0, fmt.Errorf("limit must be a non-negative number")
+		}
+	}
+
+	if v := query.Get("offset"); v != "" {
+		offset, err = strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("offset must be a non-negative number")
+		}
+	}
+
+	return limit, offset, nil
+}
+
+// QueryError wraps an error returned by Tiedot while evaluating a query.
+// It is used to tell malformed queries apart from internal failures.
+type QueryError struct {
+	Err error
+}
+
+func (e *QueryError) Error() string {
+	return "invalid query: " + e.Err.Error()
+}
+
+// ErrCollectionNotFound is returned when a collection does not exist in the database.
+var ErrCollectionNotFound = errors.New("collection not found")
+
+// ErrTooManyResults is returned when a search would read more than MaxResults documents.
+var ErrTooManyResults = errors.New("too many results")
+
+// ErrSearchTimeout is returned when reading the documents of a search takes longer than SearchTimeout.
+var ErrSearchTimeout = errors.New("search timed out")
+
+// WriteSearchError writes the error response for a failed search.
+func WriteSearchError(ctx context.Context, w http.ResponseWriter, collName string, err error) {
+	var queryErr *QueryError
+	switch {
+	case err == ErrCollectionNotFound:
+		WriteError(ctx, w, http.StatusNotFound, CodeCollectionNotFound, "collection "+collName+" does not exist")
+	case errors.As(err, &queryErr):
+		WriteError(ctx, w, http.StatusBadRequest, CodeInvalidQuery, err.Error())
+	case err == ErrTooManyResults:
+		WriteError(ctx, w, http.StatusRequestEntityTooLarge, CodeTooManyResults, "search matches too many documents, narrow down the query or use limit")
+	case err == ErrSearchTimeout:
+		WriteError(ctx, w, http.StatusServiceUnavailable, CodeTimeout, "search took too long, narrow down the query")
+	default:
+		WriteError(ctx, w, http.StatusInternalServerError, CodeInternal, "could not search collection "+collName)
+	}
+}
+
+// Search searches the given collection with the given tiedot query string and
+// returns all results that satisfy the query data.
+func (d *DBController) Search(collection string, query interface{}) (map[string]interface{}, error) {
+	return d.SearchWithOptions(collection, query, SearchOptions{})
+}
+
+// SearchIDs returns the ids of all documents of the collection matching the Tiedot
+// query in ascending order. Documents are only read to leave out soft-deleted ones
+// with hideDeleted, so this is much faster than a full search otherwise.
+func (d *DBController) SearchIDs(collection string, query interface{}, hideDeleted bool) ([]int, error) {
+	coll := d.DB.Use(collection)
+	if coll == nil {
+		return nil, ErrCollectionNotFound
+	}
+
+	ids, err := QueryIDs(coll, query)
+	if err != nil || !hideDeleted {
+		return ids, err
+	}
+
+	visible := ids[:0]
+	for _, id := range ids {
+		doc, err := coll.Read(id)
+		if err != nil || IsDeleted(doc) {
+			continue
+		}
+		visible = append(visible, id)
+	}
+	return visible, nil
+}
+
+// SearchOptions control ordering and paging of search results.
+type SearchOptions struct {
+	// Limit is the maximum number of returned documents. 0 means no limit.
+	Limit int
+	// Offset is the number of documents skipped.
+	Offset int
+	// Sort holds the keys the documents are ordered by.
+	// If empty, documents are ordered by ascending id.
+	Sort []SortKey
+	// Filters are ANDed with the query. Filters on indexed paths are added
+	// to the Tiedot query, all others are checked while reading the documents.
+	Filters []FilterGroup
+	// HideDeleted leaves out soft-deleted documents.
+	HideDeleted bool
+	// UpdatedSince leaves out documents whose updated_at is not after it.
+	// Documents without updated_at are left out too. Ignored if zero.
+	UpdatedSince time.Time
+}
+
+// SearchWithOptions works like Search but orders the results and only returns
+// the documents in the window described by limit and offset.
+// Without a sort field documents are ordered by ascending id so paging is deterministic.
+// ErrTooManyResults and ErrSearchTimeout are returned if the search exceeds
+// MaxResults or SearchTimeout.
+func (d *DBController) SearchWithOptions(collection string, query interface{}, opts SearchOptions) (map[string]interface{}, error) {
+	result := map[string]interface{}{}
+	temp := []interface{}{}
+
+	coll := d.DB.Use(collection)
+	if coll == nil {
+		return result, ErrCollectionNotFound
+	}
+
+	indexed, unindexed := SplitFilters(coll, opts.Filters)
+	if len(indexed) > 0 {
+		intersection := []interface{}{query}
+		for _, g := range indexed {
+			intersection = append(intersection, g.Query())
+		}
+		query = map[string]interface{}{"n": intersection}
+	}
+
+	ids, err := QueryIDs(coll, query)
+	if err != nil {
+		return result, err
+	}
+
+	total := len(ids)
+
+	// Without sort field, scanned filters and hidden documents the page can be cut before reading any documents.
+	readAll := len(opts.Sort) > 0 || len(unindexed) > 0 || opts.HideDeleted || !opts.UpdatedSince.IsZero()
+	if !readAll {
+		ids = PageInts(ids, opts.Limit, opts.Offset)
+	}
+
+	if d.MaxResults > 0 && len(ids) > d.MaxResults {
+		return result, ErrTooManyResults
+	}
+
+	var deadline time.Time
+	if d.SearchTimeout > 0 {
+		deadline = time.Now().Add(d.SearchTimeout)
+	}
+
+	for _, id := range ids {
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return result, ErrSearchTimeout
+		}
+
+		// To get query result document, simply read it
+		readBack, err := coll.Read(id)
+		if err != nil {
+			return result, err
+		}
+		if !MatchFilters(readBack, unindexed) || (opts.HideDeleted && IsDeleted(readBack)) {
+			continue
+		}
+		if !opts.UpdatedSince.IsZero() && !UpdatedAfter(readBack, opts.UpdatedSince) {
+			continue
+		}
+		temp = append(temp, readBack)
+	}
+
+	if readAll {
+		total = len(temp)
+		if len(opts.Sort) > 0 {
+			SortDocuments(temp, opts.Sort)
+		}
+		temp = Page(temp, opts.Limit, opts.Offset)
+	}
+
+	return PageEnvelope(temp, total, opts.Limit, opts.Offset), nil
+}
+
+// PageEnvelope wraps a page of results with the paging metadata:
+//
+//	{"results": [...], "total": 42, "limit": 10, "offset": 20, "hasMore": true}
+//
+// hasMore tells whether there are results after this page.
+func PageEnvelope(results []interface{}, total, limit, offset int) map[string]interface{} {
+	return map[string]interface{}{
+		"results": results,
+		"total":   total,
+		"limit":   limit,
+		"offset":  offset,
+		"hasMore": offset+len(results) < total,
+	}
+}
+
+// QueryIDs evaluates the Tiedot query on the collection and
+// returns the ids of all matching documents in ascending order.
+func QueryIDs(coll *db.Col, query interface{}) ([]int, error) {
+	queryResult := make(map[int]struct{})
+
+	if err := db.EvalQuery(query, coll, &queryResult); err != nil {
+		return nil, &QueryError{Err: err}
+	}
+
+	// Query result are document IDs. Sort them to get a stable order.
+	ids := make([]int, 0, len(queryResult))
+	for id := range queryResult {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	return ids, nil
+}
+
+// PageInts returns the window of s described by limit and offset.
+// A limit of 0 means no limit.
+func PageInts(s []int, limit, offset int) []int {
+	if offset > len(s) {
+		offset = len(s)
+	}
+	s = s[offset:]
+	if limit > 0 && limit < len(s) {
+		s = s[:limit]
+	}
+	return s
+}
+
+// Page returns the window of s described by limit and offset.
+// A limit of 0 means no limit.
+func Page(s []interface{}, limit, offset int) []interface{} {
+	if offset > len(s) {
+		offset = len(s)
+	}
+	s = s[offset:]
+	if limit > 0 && limit < len(s) {
+		s = s[:limit]
+	}
+	return s
+}
+
+// ReadDocumentHandler queries the given collection for a given id
+// and serves the found document if it exists.
+// The document can be reduced to the comma separated list of top-level keys in 'fields'.
+// If the If-None-Match header matches the ETag of the document, 304 is returned without body.
+// It also serves HEAD requests, which cheaply check the existence of a document:
+// the status and headers are the same as for GET but no body is sent.
+func (d *DBController) ReadDocumentHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	collName := pat.Param(ctx, "collection")
+	strid := pat.Param(ctx, "id")
+
+	id, ok := ParseID(ctx, w, strid)
+	if !ok {
+		return
+	}
+
+	coll := d.UseCollection(ctx, w)
+	if coll == nil {
+		return
+	}
+
+	result, err := d.readDocument(collName, coll, id)
+	if err == nil && d.hideDeleted(collName, r) && IsDeleted(result) {
+		err = fmt.Errorf("document %d is deleted", id)
+	}
+	if err != nil {
+		WriteError(ctx, w, http.StatusNotFound, CodeDocumentNotFound, "document "+strid+" not found")
+		return
+	}
+
+	// Let clients cache the document but always revalidate it with the ETag.
+	etag := d.SetETag(ctx, w, collName, r, result)
+	w.Header().Set("Cache-Control", "private, no-cache")
+
+	if header := r.Header.Get("If-None-Match"); header != "" && MatchETag(header, etag, false) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	WriteResponse(ctx, w, http.StatusOK, d.ResponseDocument(collName, r, result))
+}
+
+// UpdateDocumentHandler queries the given collection for a given id
+// and updates the found document with the payload json data.
+// If there is no document with the id it is created (upsert). Clients can tell
+// both cases apart by the response status: 200 for updates, 201 for creates.
+// Created documents get the default values of the collection like with POST.
+// An If-Match header must match the ETag of the current document, else 412 is returned.
+// With RequireIfMatch updates of existing documents without If-Match get 428.
+// With ?dryRun=true the document is only validated and returned.
+// With ?returnPrevious=true the response also contains the document before the update (see UpdateResponse).
+func (d *DBController) UpdateDocumentHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	collName := pat.Param(ctx, "collection")
+	strid := pat.Param(ctx, "id")
+
+	id, ok := ParseID(ctx, w, strid)
+	if !ok {
+		return
+	}
+
+	coll := d.UseCollection(ctx, w)
+	if coll == nil {
+		return
+	}
+
+	if !RequireJSON(ctx, w, r) {
+		return
+	}
+
+	// Parse JSON object from POST parameter.
+	d.LimitBody(w, r)
+	js, err := ParsePostJSON(r)
+	if err != nil {
+		WriteBodyError(ctx, w, err)
+		return
+	}
+
+	// Always replace id with correct id == avoid user errors.
+	d.SetDocumentID(js, id)
+
+	// Reading, checking the preconditions and writing must not interleave
+	// with other writes of the document, so concurrent creates of the same
+	// id cannot both insert.
+	unlock := d.locks.Lock(collName, id)
+	defer unlock()
+
+	existing, readErr := coll.Read(id)
+	if readErr != nil {
+		existing = nil
+		// Defaults are only applied to created documents, as with POST.
+		d.ApplyDefaults(collName, js)
+	}
+
+	if violations := d.ValidateDocument(collName, js); len(violations) > 0 {
+		WriteSchemaViolations(ctx, w, violations)
+		return
+	}
+
+	if !d.CheckPreconditions(ctx, w, r, collName, strid, existing) {
+		return
+	}
+
+	d.StampUpdated(collName, js, existing)
+
+	if IsDryRun(r) {
+		if err := d.CheckUnique(collName, coll, id, js); err != nil {
+			WriteInsertError(ctx, w, err)
+			return
+		}
+		WriteDryRun(ctx, w, js)
+		return
+	}
+
+	// Create the document with the given id if it does not exist yet.
+	if readErr != nil {
+		if d.NumericIDs && id > MaxNumericID {
+			WriteError(ctx, w, http.StatusBadRequest, CodeBadID, "ids of new documents must not exceed "+strconv.Itoa(MaxNumericID))
+			return
+		}
+
+		unlockUnique := d.LockUnique(collName)
+		defer unlockUnique()
+
+		if err := d.CheckUnique(collName, coll, id, js); err != nil {
+			WriteInsertError(ctx, w, err)
+			return
+		}
+
+		if err := d.Retry.Do("insert", func() error { return coll.InsertRecovery(id, js) }); err != nil {
+			WriteError(ctx, w, http.StatusInternalServerError, CodeInternal, "could not create document")
+			return
+		}
+
+		Logger(ctx).Info("created document", "collection", collName, "id", id)
+		d.publish(EventCreated, collName, id, js)
+
+		w.Header().Set("Location", d.BasePath+"/db/"+collName+"/"+strid)
+		d.SetETag(ctx, w, collName, r, js)
+		WriteResponse(ctx, w, http.StatusCreated, UpdateResponse(r, js, nil))
+		return
+	}
+
+	if err = d.UpdateDocument(collName, coll, id, js); err != nil {
+		WriteInsertError(ctx, w, err)
+		return
+	}
+
+	// Update successful
+	d.SetETag(ctx, w, collName, r, js)
+	WriteResponse(ctx, w, http.StatusOK, UpdateResponse(r, js, existing))
+}
+
+// PatchDocumentHandler queries the given collection for a given id
+// and merges the payload json data into the found document.
+// Nested objects are merged recursively, all other values are replaced.
+// With Content-Type application/merge-patch+json the payload is applied as
+// JSON merge patch (RFC 7396) instead, where null values delete keys.
+// With Content-Type application/json-patch+json the payload is an array of
+// JSON patch (RFC 6902) operations. A failing test operation returns 409 and
+// an operation that cannot be applied 422.
+// An If-Match header must match the ETag of the current document, else 412 is returned.
+// With RequireIfMatch patches without If-Match get 428.
+// With ?dryRun=true the patched document is only validated and returned.
+// With ?returnPrevious=true the response also contains the document before the update (see UpdateResponse).
+func (d *DBController) PatchDocumentHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	collName := pat.Param(ctx, "collection")
+	strid := pat.Param(ctx, "id")
+
+	id, ok := ParseID(ctx, w, strid)
+	if !ok {
+		return
+	}
+
+	coll := d.UseCollection(ctx, w)
+	if coll == nil {
+		return
+	}
+
+	if !RequireJSON(ctx, w, r, "application/merge-patch+json", MediaTypeJSONPatch) {
+		return
+	}
+
+	// Parse JSON object or JSON patch operations from PATCH parameter.
+	d.LimitBody(w, r)
+	jsonPatch := IsJSONPatch(r)
+	var js map[string]interface{}
+	var ops []JSONPatchOp
+	var err error
+	if jsonPatch {
+		ops, err = ParseJSONPatch(r)
+	} else {
+		js, err = ParsePostJSON(r)
+	}
+	if err != nil {
+		WriteBodyError(ctx, w, err)
+		return
+	}
+
+	// Reading, checking the preconditions and writing must not interleave
+	// with other writes of the document like increments.
+	unlock := d.locks.Lock(collName, id)
+	defer unlock()
+
+	doc, err := coll.Read(id)
+	if err != nil {
+		WriteError(ctx, w, http.StatusNotFound, CodeDocumentNotFound, "document "+strid+" not found")
+		return
+	}
+
+	if !d.CheckPreconditions(ctx, w, r, collName, strid, doc) {
+		return
+	}
+
+	// Patches modify nested objects in place, so the previous version must be a deep copy.
+	var previous map[string]interface{}
+	if ReturnPrevious(r) {
+		previous = copyValue(doc).(map[string]interface{})
+	}
+
+	if jsonPatch {
+		if doc, err = d.ApplyJSONPatch(collName, id, doc, ops); err != nil {
+			WriteJSONPatchError(ctx, w, err)
+			return
 		}
+	} else {
+		d.ApplyPatch(collName, id, doc, js, IsMergePatch(r))
+	}
 
-		if create {
-			fmt.Println("Creating collection", collName)
-			if err := d.DB.Create(collName); err != nil {
-				panic(err)
-			}
+	if violations := d.ValidateDocument(collName, doc); len(violations) > 0 {
+		WriteSchemaViolations(ctx, w, violations)
+		return
+	}
 
-			allCollections = append(allCollections, collName)
-		} else {
-			fmt.Printf("skipping '%s': already exists\n", collName)
+	if IsDryRun(r) {
+		if err := d.CheckUnique(collName, coll, id, doc); err != nil {
+			WriteInsertError(ctx, w, err)
+			return
 		}
+		WriteDryRun(ctx, w, doc)
+		return
 	}
 
-	if err := scanner.Err(); err != nil {
-		panic(err)
+	if err = d.UpdateDocument(collName, coll, id, doc); err != nil {
+		WriteInsertError(ctx, w, err)
+		return
 	}
 
-	// TODO maybe remove unused collections, which are not included in config file
-	// but exist in database?
+	// Update successful
+	d.SetETag(ctx, w, collName, r, doc)
+	WriteResponse(ctx, w, http.StatusOK, UpdateResponse(r, doc, previous))
 }
 
-// CreateDocumentHandler handles: POST /db/:collection.
-// A new arbitrary entry is created in the 'collection'.
-// If the collection does not exist it is created.
-func (d *DBController) CreateDocumentHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-	// Parse collection type from path.
+// IncrementDocumentHandler handles: POST /db/:collection/:id/incr.
+// Atomically adds a number to a numeric field of the document.
+// A missing field is treated as 0.
+// Payload example:
+//
+//	{
+//	  "field": "views",
+//	  "by": 1
+//	}
+//
+// 'by' defaults to 1.
+func (d *DBController) IncrementDocumentHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	collName := pat.Param(ctx, "collection")
-	fmt.Println("collection:", collName)
+	strid := pat.Param(ctx, "id")
 
-	coll := d.DB.Use(collName)
+	id, ok := ParseID(ctx, w, strid)
+	if !ok {
+		return
+	}
+
+	coll := d.UseCollection(ctx, w)
 	if coll == nil {
-		WriteResponse(ctx, w, http.StatusInternalServerError, map[string]interface{}{
-			"error": "could not use collection " + collName,
-		})
 		return
 	}
 
-	// Parse JSON object from POST parameter.
+	d.LimitBody(w, r)
 	js, err := ParsePostJSON(r)
 	if err != nil {
-		WriteResponse(ctx, w, http.StatusBadRequest, map[string]interface{}{
-			"error": "request body does not contain valid json: " + err.Error(),
-		})
+		WriteBodyError(ctx, w, err)
 		return
 	}
 
-	// Insert object into collection.
-	docID, err := coll.Insert(js)
-	if err != nil {
-		WriteResponse(ctx, w, http.StatusInternalServerError, map[string]interface{}{
-			"error": "could not insert document: " + err.Error(),
-		})
+	field, ok := js["field"].(string)
+	if !ok || field == "" || field == d.IDField {
+		WriteError(ctx, w, http.StatusBadRequest, CodeBadRequest, "field must be a non-empty string other than the id field")
 		return
 	}
 
-	// Read it back to add id to document.
-	readBack, err := coll.Read(docID)
+	by := 1.0
+	if v, exists := js["by"]; exists {
+		if by, ok = v.(float64); !ok {
+			WriteError(ctx, w, http.StatusBadRequest, CodeBadRequest, "by must be a number")
+			return
+		}
+	}
+
+	// Reading and updating must not interleave with other increments of the document.
+	unlock := d.locks.Lock(collName, id)
+	defer unlock()
+
+	doc, err := coll.Read(id)
 	if err != nil {
-		WriteResponse(ctx, w, http.StatusInternalServerError, map[string]interface{}{
-			"error": "could not insert document: " + err.Error(),
-		})
+		WriteError(ctx, w, http.StatusNotFound, CodeDocumentNotFound, "document "+strid+" not found")
 		return
 	}
 
-	readBack["id"] = strconv.Itoa(docID)
+	value := 0.0
+	if v, exists := doc[field]; exists {
+		if value, ok = v.(float64); !ok {
+			WriteError(ctx, w, http.StatusBadRequest, CodeBadRequest, "field "+field+" is not numeric")
+			return
+		}
+	}
 
-	if err := coll.Update(docID, readBack); err != nil {
-		WriteResponse(ctx, w, http.StatusInternalServerError, map[string]interface{}{
-			"error": "could not add id to document: " + err.Error(),
-		})
+	existing := map[string]interface{}{}
+	for k, v := range doc {
+		existing[k] = v
+	}
+
+	doc[field] = value + by
+	d.StampUpdated(collName, doc, existing)
+
+	if violations := d.ValidateDocument(collName, doc); len(violations) > 0 {
+		WriteSchemaViolations(ctx, w, violations)
 		return
 	}
 
-	fmt.Println("created document:", readBack)
+	if err = d.UpdateDocument(collName, coll, id, doc); err != nil {
+		WriteInsertError(ctx, w, err)
+		return
+	}
 
-	// Everything done. Return document.
-	WriteResponse(ctx, w, http.StatusCreated, readBack)
+	d.SetETag(ctx, w, collName, r, doc)
+	WriteResponse(ctx, w, http.StatusOK, doc)
 }
 
-// ReadCollectionHandler handles: GET /db/:collection.
-// Return all documents contained in the given collection.
-func (d *DBController) ReadCollectionHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-	collName := pat.Param(ctx, "collection")
-	result, err := d.Search(collName, "all")
-	if err != nil {
-		WriteResponse(ctx, w, http.StatusInternalServerError, map[string]interface{}{
-			"error": "could not read from collection " + collName,
-		})
-		return
+// RequireJSON checks that the Content-Type of the request is application/json
+// or one of the additional media types. Parameters like charset are ignored.
+// Otherwise a 415 response is written and false is returned.
+func RequireJSON(ctx context.Context, w http.ResponseWriter, r *http.Request, additional ...string) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err == nil {
+		if mediaType == MediaTypeJSON {
+			return true
+		}
+		for _, t := range additional {
+			if mediaType == t {
+				return true
+			}
+		}
 	}
 
-	// Respond with results
-	WriteResponse(ctx, w, http.StatusOK, result)
+	WriteError(ctx, w, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, "Content-Type must be "+strings.Join(append([]string{MediaTypeJSON}, additional...), " or "))
+	return false
 }
 
-// Search searches the given collection with the given tiedot query string and
-// returns all results that satisfy the query data.
-func (d *DBController) Search(collection string, query interface{}) (map[string]interface{}, error) {
-	queryResult := make(map[int]struct{})
-	result := map[string]interface{}{}
-	temp := []interface{}{}
+// ApplyPatch patches the document with the given id in place. With mergePatch
+// the patch is applied as JSON merge patch, otherwise with MergeDocuments.
+// Timestamps and the id are updated afterwards.
+func (d *DBController) ApplyPatch(collName string, id int, doc, patch map[string]interface{}, mergePatch bool) {
+	existing := map[string]interface{}{}
+	for k, v := range doc {
+		existing[k] = v
+	}
 
-	coll := d.DB.Use(collection)
-	if coll == nil {
-		return result, fmt.Errorf("could not use collection")
+	if mergePatch {
+		MergePatch(doc, patch)
+	} else {
+		MergeDocuments(doc, patch)
 	}
+	d.StampUpdated(collName, doc, existing)
 
-	if err := db.EvalQuery(query, coll, &queryResult); err != nil {
-		return result, err
+	// Always replace id with correct id == avoid user errors.
+	d.SetDocumentID(doc, id)
+}
+
+// IsMergePatch reports whether the request body is a JSON merge patch.
+func IsMergePatch(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/merge-patch+json"
+}
+
+// MergePatch applies the JSON merge patch to the document as described in RFC 7396.
+// Null values delete keys and objects are patched recursively.
+func MergePatch(dst, patch map[string]interface{}) {
+	for k, v := range patch {
+		if v == nil {
+			delete(dst, k)
+			continue
+		}
+
+		if patchObj, ok := v.(map[string]interface{}); ok {
+			dstObj, ok := dst[k].(map[string]interface{})
+			if !ok {
+				dstObj = map[string]interface{}{}
+			}
+			MergePatch(dstObj, patchObj)
+			dst[k] = dstObj
+			continue
+		}
+
+		dst[k] = v
 	}
+}
 
-	// Query result are document IDs.
-	for id := range queryResult {
-		// To get query result document, simply read it
-		readBack, err := coll.Read(id)
-		if err != nil {
-			return result, err
+// MergeDocuments merges all keys of src into dst. If both values for a key
+// are objects they are merged recursively, otherwise the value of src wins.
+func MergeDocuments(dst, src map[string]interface{}) {
+	for k, v := range src {
+		srcObj, srcIsObj := v.(map[string]interface{})
+		dstObj, dstIsObj := dst[k].(map[string]interface{})
+
+		if srcIsObj && dstIsObj {
+			MergeDocuments(dstObj, srcObj)
+			continue
 		}
-		temp = append(temp, readBack)
+
+		dst[k] = v
 	}
+}
 
-	result["results"] = temp
+// DeleteDocumentHandler deletes document with given id from given collection.
+// In collections with soft deletes the document is only marked with deleted_at.
+func (d *DBController) DeleteDocumentHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	d.deleteDocument(ctx, w, r, false)
+}
 
-	return result, nil
+// HardDeleteDocumentHandler handles: DELETE /db/:collection/:id/hard.
+// Permanently removes the document, even in collections with soft deletes.
+func (d *DBController) HardDeleteDocumentHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	d.deleteDocument(ctx, w, r, true)
 }
 
-// ReadDocumentHandler queries the given collection for a given id
-// and serves the found document if it exists.
-func (d *DBController) ReadDocumentHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+// deleteDocument removes the document or soft-deletes it if the collection is
+// configured so and hard is not set.
+func (d *DBController) deleteDocument(ctx context.Context, w http.ResponseWriter, r *http.Request, hard bool) {
 	collName := pat.Param(ctx, "collection")
 	strid := pat.Param(ctx, "id")
 
-	id, err := strconv.Atoi(strid)
-	if err != nil {
-		WriteResponse(ctx, w, http.StatusBadRequest, map[string]interface{}{
-			"error": "id cannot be parsed to number",
-		})
+	id, ok := ParseID(ctx, w, strid)
+	if !ok {
 		return
 	}
 
-	coll := d.DB.Use(collName)
+	coll := d.UseCollection(ctx, w)
 	if coll == nil {
-		WriteResponse(ctx, w, http.StatusInternalServerError, map[string]interface{}{
-			"error": "could not use collection " + collName,
-		})
 		return
 	}
 
-	result, err := coll.Read(id)
-	if err != nil {
-		WriteResponse(ctx, w, 422, map[string]interface{}{
-			"error": "document not found",
+	if !hard && d.CollectionConfig(collName).SoftDelete {
+		doc, err := coll.Read(id)
+		if err != nil {
+			WriteError(ctx, w, http.StatusNotFound, CodeDocumentNotFound, "document "+strid+" not found")
+			return
+		}
+
+		if _, err := d.SoftDeleteDocument(collName, coll, id, doc); err != nil {
+			WriteError(ctx, w, http.StatusInternalServerError, CodeInternal, "could not delete document with id "+strid)
+			return
+		}
+
+		WriteResponse(ctx, w, http.StatusOK, map[string]interface{}{
+			"id":           strid,
+			DeletedAtField: doc[DeletedAtField],
 		})
 		return
 	}
 
-	WriteResponse(ctx, w, http.StatusOK, result)
+	if err := d.DeleteDocument(collName, coll, id); err != nil {
+		WriteError(ctx, w, http.StatusInternalServerError, CodeInternal, "could not delete document with id "+strid)
+		return
+	}
+
+	WriteResponse(ctx, w, http.StatusOK, map[string]interface{}{
+		"id": strid,
+	})
 }
 
-// UpdateDocumentHandler queries the given collection for a given id
-// and updates the found document with the payload json data.
-func (d *DBController) UpdateDocumentHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-	collName := pat.Param(ctx, "collection")
-	strid := pat.Param(ctx, "id")
+// DeleteDocument removes the document with the given id permanently.
+func (d *DBController) DeleteDocument(collName string, coll *db.Col, id int) error {
+	if err := d.Retry.Do("delete", func() error { return coll.Delete(id) }); err != nil {
+		return err
+	}
 
-	id, err := strconv.Atoi(strid)
-	fmt.Println(strid, id)
-	if err != nil {
-		WriteResponse(ctx, w, http.StatusBadRequest, map[string]interface{}{
-			"error": "id cannot be parsed to number",
-		})
-		return
+	d.publish(EventDeleted, collName, id, nil)
+	return nil
+}
+
+// SoftDeleteDocument marks the document with the given id as deleted by setting
+// its deleted_at field and reports whether it was not deleted before.
+func (d *DBController) SoftDeleteDocument(collName string, coll *db.Col, id int, doc map[string]interface{}) (bool, error) {
+	if IsDeleted(doc) {
+		return false, nil
 	}
 
-	coll := d.DB.Use(collName)
-	if coll == nil {
-		WriteResponse(ctx, w, http.StatusInternalServerError, map[string]interface{}{
-			"error": "could not use collection " + collName,
+	doc[DeletedAtField] = now()
+	if err := d.Retry.Do("update", func() error { return coll.Update(id, doc) }); err != nil {
+		return false, err
+	}
+
+	d.publish(EventDeleted, collName, id, nil)
+	return true, nil
+}
+
+// ListCollectionsHandler handles: GET /db.
+// Returns all collections in the database with their approximate document counts.
+func (d *DBController) ListCollectionsHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	collections := []interface{}{}
+
+	allCollections := d.DB.AllCols()
+	sort.Strings(allCollections)
+
+	for _, collName := range allCollections {
+		count := 0
+		if coll := d.DB.Use(collName); coll != nil {
+			count = coll.ApproxDocCount()
+		}
+
+		collections = append(collections, map[string]interface{}{
+			"name":  collName,
+			"count": count,
 		})
+	}
+
+	WriteResponse(ctx, w, http.StatusOK, collections)
+}
+
+// CreateCollectionHandler handles: POST /db.
+// Creates a new collection with the name given in the payload.
+// Payload example:
+//
+//	{
+//	  "collection": "users"
+//	}
+func (d *DBController) CreateCollectionHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if !RequireJSON(ctx, w, r) {
 		return
 	}
 
 	// Parse JSON object from POST parameter.
+	d.LimitBody(w, r)
 	js, err := ParsePostJSON(r)
 	if err != nil {
-		WriteResponse(ctx, w, http.StatusBadRequest, map[string]interface{}{
-			"error": "request body does not contain valid json: " + err.Error(),
-		})
+		WriteBodyError(ctx, w, err)
 		return
 	}
 
-	// Always replace id with correct id == avoid user errors.
-	js["id"] = strconv.Itoa(id)
+	collName, ok := js["collection"].(string)
+	if !ok || collName == "" {
+		WriteError(ctx, w, http.StatusBadRequest, CodeBadRequest, "request body does not contain a collection name")
+		return
+	}
 
-	if err = coll.Update(id, js); err != nil {
-		WriteResponse(ctx, w, http.StatusInternalServerError, map[string]interface{}{
-			"error": "could not update document",
-		})
+	if !ValidCollectionName(collName) {
+		WriteError(ctx, w, http.StatusBadRequest, CodeInvalidName, "collection name has invalid characters")
 		return
 	}
 
-	// Update successful
-	WriteResponse(ctx, w, http.StatusOK, js)
+	created, err := d.CreateCollection(collName)
+	if err == ErrCollectionLimit {
+		WriteCollectionLimitError(ctx, w)
+		return
+	}
+	if err != nil {
+		WriteError(ctx, w, http.StatusInternalServerError, CodeInternal, "could not create collection "+collName)
+		return
+	}
+	if !created {
+		WriteError(ctx, w, http.StatusConflict, CodeConflict, "collection "+collName+" already exists")
+		return
+	}
+
+	Logger(ctx).Info("created collection", "collection", collName)
+
+	WriteResponse(ctx, w, http.StatusCreated, map[string]interface{}{
+		"collection": collName,
+	})
 }
 
-// DeleteDocumentHandler deletes document with given id from given collection.
-func (d *DBController) DeleteDocumentHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+// DeleteCollectionHandler handles: DELETE /db/:collection.
+// Drops the given collection including all its documents.
+func (d *DBController) DeleteCollectionHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	collName := pat.Param(ctx, "collection")
-	strid := pat.Param(ctx, "id")
 
-	id, err := strconv.Atoi(strid)
-	fmt.Println(strid, id)
+	coll := d.UseCollection(ctx, w)
+	if coll == nil {
+		return
+	}
+
+	count := coll.ApproxDocCount()
+
+	err := d.DB.Drop(collName)
+	d.Cache.RemoveCollection(collName)
 	if err != nil {
-		WriteResponse(ctx, w, http.StatusBadRequest, map[string]interface{}{
-			"error": "id cannot be parsed to number",
-		})
+		WriteError(ctx, w, http.StatusInternalServerError, CodeInternal, "could not drop collection "+collName)
 		return
 	}
 
-	coll := d.DB.Use(collName)
+	Logger(ctx).Info("dropped collection", "collection", collName, "documents", count)
+
+	WriteResponse(ctx, w, http.StatusOK, map[string]interface{}{
+		"collection": collName,
+	})
+}
+
+// ScrubCollectionHandler handles: POST /db/:collection/scrub.
+// Repairs and defragments the collection, e.g. to reclaim space after bulk deletes.
+// Blocks until the scrub is done.
+func (d *DBController) ScrubCollectionHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	collName := pat.Param(ctx, "collection")
+
+	if coll := d.UseCollection(ctx, w); coll == nil {
+		return
+	}
+
+	start := time.Now()
+	if err := d.DB.Scrub(collName); err != nil {
+		WriteError(ctx, w, http.StatusInternalServerError, CodeInternal, "could not scrub collection "+collName)
+		return
+	}
+
+	Logger(ctx).Info("scrubbed collection", "collection", collName, "duration", time.Since(start))
+
+	WriteResponse(ctx, w, http.StatusOK, map[string]interface{}{
+		"collection": collName,
+	})
+}
+
+// CountDocumentsHandler handles: GET /db/:collection/count.
+// Returns the exact number of documents in the collection, or
+// a fast approximation if the query param 'approx' is true.
+// Soft-deleted documents are only counted with ?includeDeleted=true like in reads,
+// the approximation always includes them.
+func (d *DBController) CountDocumentsHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	collName := pat.Param(ctx, "collection")
+
+	coll := d.UseCollection(ctx, w)
 	if coll == nil {
-		WriteResponse(ctx, w, http.StatusInternalServerError, map[string]interface{}{
-			"error": "could not use collection " + collName,
-		})
 		return
 	}
 
-	if err := coll.Delete(id); err != nil {
-		WriteResponse(ctx, w, http.StatusInternalServerError, map[string]interface{}{
-			"error": "could not delete document with id " + strid,
+	approx := r.URL.Query().Get("approx") == "true"
+
+	count := 0
+	switch {
+	case approx:
+		count = coll.ApproxDocCount()
+	case d.hideDeleted(collName, r):
+		// Deleted documents can only be told apart by decoding them.
+		ForEachMatchingDocument(coll, nil, true, func(id int, doc map[string]interface{}) bool {
+			count++
+			return true
+		})
+	default:
+		coll.ForEachDoc(func(id int, doc []byte) bool {
+			count++
+			return true
 		})
-		return
 	}
 
 	WriteResponse(ctx, w, http.StatusOK, map[string]interface{}{
-		"id": strid,
+		"count":  count,
+		"approx": approx,
 	})
 }
 
 // SearchCollectionHandler handles: POST /db/search/:collection.
 // Return all documents contained in the given collection fulfilling the query properties.
-// Expects a Tiedot query string. See: https://github.com/HouzuoGuo/tiedot/wiki/Query-processor-and-index
+// Expects a Tiedot query. See: https://github.com/HouzuoGuo/tiedot/wiki/Query-processor-and-index
 // Payload example:
-// {
-//	 "query": "[{"eq": "JohnAppleseed", "in": ["username"], "limit": 1}]"
-//}
-// TODO
+//
+//	{
+//	  "query": [{"eq": "JohnAppleseed", "in": ["username"], "limit": 1}]
+//	}
+//
+// With ?idsOnly=true only a JSON array of the matching ids is returned (see SearchIDs).
 func (d *DBController) SearchCollectionHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	collName, ok := CollectionParam(ctx, w)
+	if !ok {
+		return
+	}
+
 	// Parse JSON object from POST parameter.
-	//	jsonQuery, err := ParsePostJSON(r)
-	//	if err != nil {
-	//		WriteResponse(ctx, w, http.StatusBadRequest, map[string]interface{}{
-	//			"error": "request body does not contain valid json: " + err.Error(),
-	//		})
-	//		return
-	//	}
+	js, err := ParsePostJSON(r)
+	if err != nil {
+		WriteError(ctx, w, http.StatusBadRequest, CodeInvalidJSON, "request body does not contain valid json: "+err.Error())
+		return
+	}
+
+	query, ok := js["query"]
+	if !ok {
+		WriteError(ctx, w, http.StatusBadRequest, CodeBadRequest, "request body does not contain a query")
+		return
+	}
+
+	if r.URL.Query().Get("idsOnly") == "true" {
+		ids, err := d.SearchIDs(collName, query, d.hideDeleted(collName, r))
+		if err != nil {
+			WriteSearchError(ctx, w, collName, err)
+			return
+		}
+		WriteResponse(ctx, w, http.StatusOK, ids)
+		return
+	}
+
+	result, err := d.SearchWithOptions(collName, query, SearchOptions{
+		HideDeleted: d.hideDeleted(collName, r),
+	})
+	if err != nil {
+		WriteSearchError(ctx, w, collName, err)
+		return
+	}
+	result["results"] = d.ResponseDocuments(collName, r, result["results"].([]interface{}))
 
-	// sorry no more time for now..
+	// Respond with results
+	WriteResponse(ctx, w, http.StatusOK, result)
 }
 
 func main() {
 	// Read command line flags.
 	var port int
+	var host, addr string
+	var tlsCert, tlsKey string
+	var htpasswd string
+	var unixSocket string
+	var trailingSlash string
+	var shutdownTimeout time.Duration
+	var readTimeout, writeTimeout, idleTimeout time.Duration
+	var metricsInterval time.Duration
+	var logLevel slog.Level
+	var accessLog string
+	var corsOrigins string
+	var storageFolder, configFile string
+	var skipInvalid, prune bool
+	var maxBody int64
+	var numericIDs, timestamps, autoCreate bool
+	var idField string
+	var namePattern string
+	var maxResults, maxCollections int
+	var defaultLimit int
+	var maxDepth, maxKeys int
+	var retries int
+	var retryBackoff time.Duration
+	var basePath string
+	var cacheSize int
+	var readOnly bool
+	var requireIfMatch bool
+	var searchTimeout time.Duration
+	var rps float64
+	var burst int
 	flag.IntVar(&port, "p", 8888, "specify port to use")
+	flag.StringVar(&host, "host", "localhost", "host or IP to listen on, use 0.0.0.0 to listen on all interfaces")
+	flag.StringVar(&addr, "addr", "", "address to listen on, e.g. :8888 (overrides -host and -p)")
+	flag.StringVar(&unixSocket, "unix", "", "path of a Unix domain socket to listen on instead of TCP (overrides -addr)")
+	flag.StringVar(&tlsCert, "tlscert", "", "TLS certificate file, serves HTTPS together with -tlskey")
+	flag.StringVar(&htpasswd, "htpasswd", "", "htpasswd file with users allowed via HTTP Basic auth, empty disables auth")
+	flag.StringVar(&tlsKey, "tlskey", "", "TLS private key file, serves HTTPS together with -tlscert")
+	flag.DurationVar(&shutdownTimeout, "shutdown", 10*time.Second, "time to wait for in-flight requests on shutdown")
+	flag.DurationVar(&readTimeout, "readtimeout", 15*time.Second, "maximum duration for reading a whole request, 0 means no timeout")
+	flag.DurationVar(&writeTimeout, "writetimeout", 60*time.Second, "maximum duration for writing a response, 0 means no timeout (raise it for large exports)")
+	flag.DurationVar(&idleTimeout, "idletimeout", 120*time.Second, "maximum duration to keep idle keep-alive connections open, 0 means no timeout")
+	flag.StringVar(&storageFolder, "storage", DBFolder, "folder where the database is stored")
+	flag.StringVar(&configFile, "config", CollectionsConfig, "file containing the collections to create on startup")
+	flag.BoolVar(&skipInvalid, "skipinvalid", false, "skip invalid lines in the collections config instead of aborting")
+	flag.BoolVar(&prune, "prune", false, "drop all collections that are not listed in the collections config (deletes data!)")
+	flag.DurationVar(&metricsInterval, "metricsinterval", 30*time.Second, "interval for refreshing the collection document count metrics")
+	flag.Int64Var(&maxBody, "maxbody", 1<<20, "maximum size of request bodies in bytes, 0 means no limit")
+	flag.IntVar(&maxDepth, "maxdepth", 0, "maximum nesting depth of documents, 0 means no limit")
+	flag.IntVar(&maxKeys, "maxkeys", 0, "maximum number of keys per object in documents, 0 means no limit")
+	flag.StringVar(&namePattern, "namepattern", DefaultNamePattern, "regular expression for valid collection names, must match the whole name")
+	flag.StringVar(&idField, "idfield", "id", "field the document id is stored in, empty to not store ids in documents")
+	flag.BoolVar(&numericIDs, "numericids", false, "store document ids as numbers instead of strings, use it for new databases only")
+	flag.BoolVar(&timestamps, "timestamps", false, "maintain created_at and updated_at fields in all collections")
+	flag.BoolVar(&autoCreate, "autocreate", false, "create missing collections when documents are created in them")
+	flag.BoolVar(&readOnly, "readonly", false, "serve reads only and reject all writes with 405")
+	flag.BoolVar(&requireIfMatch, "requireifmatch", false, "reject PUT and PATCH of existing documents without If-Match header with 428")
+	flag.IntVar(&cacheSize, "cache", 0, "number of documents kept in the read cache, 0 disables the cache")
+	flag.IntVar(&maxCollections, "maxcollections", 0, "maximum number of collections that can be created at runtime, 0 means no limit")
+	flag.IntVar(&maxResults, "maxresults", 0, "maximum number of documents a search may read, 0 means no limit")
+	flag.IntVar(&defaultLimit, "defaultlimit", 100, "limit of collection reads without limit param, 0 means no limit")
+	flag.DurationVar(&searchTimeout, "searchtimeout", 0, "maximum duration for reading the documents of a search, 0 means no timeout")
+	flag.IntVar(&retries, "retries", 0, "number of retries of document writes failing with transient errors")
+	flag.DurationVar(&retryBackoff, "retrybackoff", 50*time.Millisecond, "wait before the first retry of a failed write, doubled for every further retry")
+	flag.Float64Var(&rps, "rps", 0, "allowed requests per second per client IP, 0 means no limit")
+	flag.IntVar(&burst, "burst", 20, "maximum burst of requests per client IP when rate limiting")
+	flag.StringVar(&basePath, "basepath", "", "prefix to serve all routes under, e.g. /api/v1 (/health and /ready are also served at the root)")
+	flag.StringVar(&trailingSlash, "trailingslash", TrailingSlashStrict, "handling of paths with trailing slash: strict (404), strip or redirect")
+	flag.StringVar(&corsOrigins, "cors", "", "comma separated list of allowed CORS origins (* allows all)")
+	flag.StringVar(&accessLog, "accesslog", "", "log every request in the given format: combined or json, empty disables the access log")
+	flag.TextVar(&logLevel, "loglevel", slog.LevelInfo, "log level: debug, info, warn or error")
 	flag.Parse()
 
+	// Log structured JSON to stdout.
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+		Level: logLevel,
+	})))
+
+	nameRegexp, err := CompileNamePattern(namePattern)
+	if err != nil {
+		slog.Error("invalid -namepattern", "error", err)
+		os.Exit(1)
+	}
+	CollectionNameRegexp = nameRegexp
+
+	basePath, err = CleanBasePath(basePath)
+	if err != nil {
+		slog.Error("invalid -basepath", "error", err)
+		os.Exit(1)
+	}
+
+	if (tlsCert == "") != (tlsKey == "") {
+		slog.Error("-tlscert and -tlskey must be set together")
+		os.Exit(1)
+	}
+
 	// Create folder if it doesn't exist.
-	DB, err := db.OpenDB(DBFolder)
+	DB, err := db.OpenDB(storageFolder)
 	if err != nil {
-		panic(err)
+		slog.Error("could not open database, check that the storage folder is writable and not corrupted",
+			"storage", storageFolder, "error", err)
+		os.Exit(1)
 	}
 
 	dbController := NewDBController(DB)
+	dbController.MaxBodySize = maxBody
+	dbController.NumericIDs = numericIDs
+	dbController.IDField = idField
+	dbController.Timestamps = timestamps
+	dbController.AutoCreate = autoCreate
+	dbController.MaxCollections = maxCollections
+	dbController.ReadOnly = readOnly
+	dbController.RequireIfMatch = requireIfMatch
+	dbController.MaxResults = maxResults
+	dbController.SearchTimeout = searchTimeout
+	dbController.DefaultLimit = defaultLimit
+	dbController.MaxDepth = maxDepth
+	dbController.MaxKeys = maxKeys
+	dbController.Retry = RetryPolicy{Retries: retries, Backoff: retryBackoff}
+	dbController.BasePath = basePath
+	if cacheSize > 0 {
+		dbController.Cache = NewDocumentCache(cacheSize)
+	}
 
-	dbController.SetupCollections(CollectionsConfig)
-	fmt.Println("..done creating collections.")
+	setupOpts := SetupOptions{
+		SkipInvalid: skipInvalid,
+		Prune:       prune,
+	}
+	if err := dbController.SetupCollections(configFile, setupOpts); err != nil {
+		if !skipInvalid {
+			slog.Error("could not set up collections, fix the config or start with -skipinvalid",
+				"config", configFile, "storage", storageFolder, "error", err)
+			DB.Close()
+			os.Exit(1)
+		}
+		slog.Warn("some collections could not be set up", "config", configFile, "error", err)
+	}
+	slog.Info("done creating collections")
 
 	// Create http router.
 	mux := goji.NewMux()
+	mux.UseC(RequestLogger)
+	if accessLog != "" {
+		middleware, err := AccessLog(accessLog, os.Stdout)
+		if err != nil {
+			slog.Error("invalid -accesslog", "error", err)
+			os.Exit(1)
+		}
+		mux.UseC(middleware)
+	}
+	mux.UseC(Metrics)
+	mux.UseC(Negotiate)
+	mux.UseC(Gzip)
+	// Inside Gzip so the 500 for a panic goes through the compressed response.
+	mux.UseC(Recoverer)
+	mux.UseC(CORS(strings.Split(corsOrigins, ",")))
+	if rps > 0 {
+		mux.UseC(NewRateLimiter(rps, burst).Middleware)
+	}
+	if htpasswd != "" {
+		auth, err := LoadHtpasswd(htpasswd)
+		if err != nil {
+			slog.Error("could not load htpasswd file", "file", htpasswd, "error", err)
+			os.Exit(1)
+		}
+		mux.UseC(auth.Middleware)
+	}
+
+	// Prometheus metrics.
+	mux.Handle(pat.Get("/metrics"), promhttp.Handler())
+	stopMetrics := dbController.StartCollectionMetrics(metricsInterval)
+
+	// Assign all routes, including the probes for health checks, to the handler methods.
+	routes := dbController.Routes()
+	for _, route := range routes {
+		h := route.Handler
+		if route.Writable {
+			h = dbController.Writable(h)
+		}
+		mux.HandleFuncC(route.Pattern(), h)
+	}
+
+	// Known paths requested with an unsupported method get 405 instead of 404.
+	// Routes match in order, so these must come last.
+	mux.HandleFuncC(pat.New("/metrics"), MethodNotAllowed("GET, HEAD"))
+	paths, allow := AllowedMethods(routes)
+	for _, path := range paths {
+		mux.HandleFuncC(pat.New(path), MethodNotAllowed(allow[path]))
+	}
 
-	// And assign all the crud routes to the handler methods.
-	mux.HandleFuncC(pat.Get("/db/:collection"), dbController.ReadCollectionHandler)
+	// Trailing slashes are handled before stripping the base path so redirects keep it.
+	handler, err := TrailingSlash(trailingSlash, StripBasePath(basePath, mux))
+	if err != nil {
+		slog.Error("invalid -trailingslash", "error", err)
+		os.Exit(1)
+	}
 
-	mux.HandleFuncC(pat.Post("/db/:collection"), dbController.CreateDocumentHandler)
-	mux.HandleFuncC(pat.Get("/db/:collection/:id"), dbController.ReadDocumentHandler)
-	mux.HandleFuncC(pat.Put("/db/:collection/:id"), dbController.UpdateDocumentHandler)
-	mux.HandleFuncC(pat.Delete("/db/:collection/:id"), dbController.DeleteDocumentHandler)
+	if addr == "" {
+		addr = net.JoinHostPort(host, strconv.Itoa(port))
+	}
 
-	// TODO this method still needs implementation..
-	mux.HandleFuncC(pat.Post("/db/search/:collection"), dbController.SearchCollectionHandler)
+	server := &http.Server{
+		Addr:         addr,
+		Handler:      handler,
+		ReadTimeout:  readTimeout,
+		WriteTimeout: writeTimeout,
+		IdleTimeout:  idleTimeout,
+	}
+	// Shutdown waits for active requests, so event streams and watchers must end.
+	server.RegisterOnShutdown(dbController.Events.Close)
+
+	// Shut down gracefully on SIGINT/SIGTERM so in-flight requests can finish
+	// and the database is closed properly.
+	done := make(chan struct{})
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+
+		slog.Info("shutting down")
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		if err := server.Shutdown(ctx); err != nil {
+			slog.Error("could not shut down http server", "error", err)
+		}
+		close(done)
+	}()
+
+	var listener net.Listener
+	if unixSocket != "" {
+		listener, err = ListenUnix(unixSocket)
+	} else {
+		listener, err = net.Listen("tcp", server.Addr)
+	}
+	if err != nil {
+		slog.Error("could not listen", "error", err)
+		DB.Close()
+		os.Exit(1)
+	}
 
 	// Start http server.
-	fmt.Println("Listening on localhost:", port)
-	http.ListenAndServe("localhost:"+strconv.Itoa(port), mux)
+	if tlsCert != "" {
+		slog.Info("listening", "addr", listener.Addr().String(), "tls", true)
+		err = server.ServeTLS(listener, tlsCert, tlsKey)
+	} else {
+		slog.Info("listening", "addr", listener.Addr().String())
+		err = server.Serve(listener)
+	}
+	if err != http.ErrServerClosed {
+		slog.Error("could not run http server", "error", err)
+	} else {
+		<-done
+	}
+
+	stopMetrics()
+	if err := DB.Close(); err != nil {
+		slog.Error("could not close database", "error", err)
+	}
+	slog.Info("done shutting down")
 }
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"goji.io"
+	"goji.io/middleware"
+	"golang.org/x/net/context"
+)
+
+var (
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "crudmachine_http_requests_total",
+		Help: "Number of handled http requests.",
+	}, []string{"handler", "code"})
+
+	requestErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "crudmachine_http_request_errors_total",
+		Help: "Number of http requests answered with a 4xx or 5xx status.",
+	}, []string{"handler", "code"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "crudmachine_http_request_duration_seconds",
+		Help:    "Latency of handled http requests.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"handler", "code"})
+
+	collectionDocuments = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "crudmachine_collection_documents",
+		Help: "Approximate number of documents per collection.",
+	}, []string{"collection"})
+)
+
+func init() {
+	prometheus.MustRegister(requestsTotal, requestErrorsTotal, requestDuration, collectionDocuments)
+}
+
+// Metrics is a middleware that records request counts, errors and
+// latencies labeled by the matched route and the response status.
+func Metrics(h goji.Handler) goji.Handler {
+	return goji.HandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		handler := "unmatched"
+		if p := middleware.Pattern(ctx); p != nil {
+			handler = r.Method + " " + fmt.Sprint(p)
+		}
+
+		sw := NewStatusWriter(w)
+		start := time.Now()
+		h.ServeHTTPC(ctx, sw, r)
+
+		code := strconv.Itoa(sw.status)
+		requestsTotal.WithLabelValues(handler, code).Inc()
+		requestDuration.WithLabelValues(handler, code).Observe(time.Since(start).Seconds())
+		if sw.status >= 400 {
+			requestErrorsTotal.WithLabelValues(handler, code).Inc()
+		}
+	})
+}
+
+// StartCollectionMetrics refreshes the per-collection document count gauge
+// in the given interval until the returned stop function is called.
+func (d *DBController) StartCollectionMetrics(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			d.RefreshCollectionMetrics()
+
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// RefreshCollectionMetrics sets the document count gauge for all collections.
+func (d *DBController) RefreshCollectionMetrics() {
+	collectionDocuments.Reset()
+	for _, collName := range d.DB.AllCols() {
+		if coll := d.DB.Use(collName); coll != nil {
+			collectionDocuments.WithLabelValues(collName).Set(float64(coll.ApproxDocCount()))
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime/debug"
+
+	"goji.io"
+	"golang.org/x/net/context"
+)
+
+// statusWriter wraps a http.ResponseWriter and records
+// the status code and the number of bytes written.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int
+	wroteHeader bool
+}
+
+// NewStatusWriter wraps the http.ResponseWriter.
+func NewStatusWriter(w http.ResponseWriter) *statusWriter {
+	return &statusWriter{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+// Unwrap returns the wrapped http.ResponseWriter for http.ResponseController.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Hijack passes hijacking through so WebSocket upgrades keep working.
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	w.status = http.StatusSwitchingProtocols
+	w.wroteHeader = true
+	return h.Hijack()
+}
+
+// Flush passes flushes through so streaming handlers keep working.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Recoverer is a middleware that recovers from panics in handlers so a single
+// bad request cannot crash the server. The panic is logged with its stack trace
+// and a 500 error is returned if no response was written yet.
+func Recoverer(h goji.Handler) goji.Handler {
+	return goji.HandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		sw := NewStatusWriter(w)
+
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			// The http server uses this panic to abort responses on purpose.
+			if rec == http.ErrAbortHandler {
+				panic(rec)
+			}
+
+			Logger(ctx).Error("recovered from panic", "panic", rec, "stack", string(debug.Stack()))
+
+			if !sw.wroteHeader {
+				WriteError(ctx, sw, http.StatusInternalServerError, CodeInternal, "internal server error")
+			}
+		}()
+
+		h.ServeHTTPC(ctx, sw, r)
+	})
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"goji.io"
+	"goji.io/pat"
+	"golang.org/x/net/context"
+)
+
+func TestRecovererWithGzip(t *testing.T) {
+	// Same order as in main.
+	mux := goji.NewMux()
+	mux.UseC(Negotiate)
+	mux.UseC(Gzip)
+	mux.UseC(Recoverer)
+	mux.HandleFuncC(pat.Get("/panic"), func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+
+	r := httptest.NewRequest("GET", "/panic", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+
+	var body struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("body %q is not a json error envelope: %v", w.Body.String(), err)
+	}
+	if body.Error.Code != CodeInternal {
+		t.Errorf("error code = %q, want %q", body.Error.Code, CodeInternal)
+	}
+}
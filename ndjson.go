@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"goji.io/pat"
+	"golang.org/x/net/context"
+)
+
+const (
+	// exportFlushInterval is the number of documents written between flushes.
+	exportFlushInterval = 100
+	// importMaxLineSize is the maximum size of a single line to import in bytes.
+	importMaxLineSize = 16 << 20
+)
+
+// ExportCollectionHandler handles: GET /db/:collection/export.
+// Streams all documents of the collection as newline delimited JSON.
+// Documents are written as they are read so memory usage stays flat.
+func (d *DBController) ExportCollectionHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	collName := pat.Param(ctx, "collection")
+
+	coll := d.UseCollection(ctx, w)
+	if coll == nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	count := 0
+	var err error
+
+	coll.ForEachDoc(func(id int, doc []byte) bool {
+		if _, err = w.Write(doc); err != nil {
+			return false
+		}
+		if _, err = w.Write([]byte("\n")); err != nil {
+			return false
+		}
+
+		count++
+		if flusher != nil && count%exportFlushInterval == 0 {
+			flusher.Flush()
+		}
+		return true
+	})
+
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	if err != nil {
+		Logger(ctx).Error("could not export collection", "collection", collName, "exported", count, "error", err)
+		return
+	}
+
+	Logger(ctx).Info("exported collection", "collection", collName, "exported", count)
+}
+
+// ImportCollectionHandler handles: POST /db/:collection/import.
+// Reads newline delimited JSON from the request body and inserts every line
+// as a new document. Lines are processed one by one so memory usage stays flat.
+// Failing lines do not abort the import and are reported with their line number.
+func (d *DBController) ImportCollectionHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	collName := pat.Param(ctx, "collection")
+
+	coll := d.UseCollection(ctx, w)
+	if coll == nil {
+		return
+	}
+
+	inserted := 0
+	errs := []interface{}{}
+
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 64*1024), importMaxLineSize)
+
+	for line := 1; scanner.Scan(); line++ {
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+
+		js := map[string]interface{}{}
+		if err := json.Unmarshal(raw, &js); err != nil {
+			errs = append(errs, map[string]interface{}{
+				"line":  line,
+				"error": NewError(CodeInvalidJSON, "line does not contain a valid json object: "+err.Error()),
+			})
+			continue
+		}
+
+		d.ApplyDefaults(collName, js)
+		if violations := d.ValidateDocument(collName, js); len(violations) > 0 {
+			errs = append(errs, map[string]interface{}{
+				"line":  line,
+				"error": SchemaViolationError(violations),
+			})
+			continue
+		}
+
+		if _, _, err := d.InsertDocument(collName, coll, js); err != nil {
+			errs = append(errs, map[string]interface{}{
+				"line":  line,
+				"error": InsertError(err),
+			})
+			continue
+		}
+
+		inserted++
+	}
+
+	if err := scanner.Err(); err != nil {
+		Logger(ctx).Error("could not read import", "collection", collName, "inserted", inserted, "error", err)
+		WriteResponse(ctx, w, http.StatusBadRequest, map[string]interface{}{
+			"error":    NewError(CodeBadRequest, "could not read request body: "+err.Error()),
+			"inserted": inserted,
+			"errors":   errs,
+		})
+		return
+	}
+
+	Logger(ctx).Info("imported collection", "collection", collName, "inserted", inserted, "failed", len(errs))
+
+	WriteResponse(ctx, w, http.StatusOK, map[string]interface{}{
+		"inserted": inserted,
+		"errors":   errs,
+	})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"goji.io"
+	"golang.org/x/net/context"
+)
+
+// Media types of the supported response formats.
+const (
+	MediaTypeJSON    = "application/json"
+	MediaTypeMsgpack = "application/msgpack"
+)
+
+// ResponseFormat returns the negotiated media type for the response.
+// Defaults to JSON.
+func ResponseFormat(ctx context.Context) string {
+	if f, ok := ctx.Value(responseFormatKey).(string); ok {
+		return f
+	}
+	return MediaTypeJSON
+}
+
+// PrettyResponse reports whether JSON responses should be indented.
+func PrettyResponse(ctx context.Context) bool {
+	pretty, _ := ctx.Value(prettyKey).(bool)
+	return pretty
+}
+
+// Negotiate is a middleware that selects the response format from the
+// Accept header and stores it in the context for WriteResponse.
+// Unsupported or missing Accept headers fall back to JSON.
+// With ?pretty=true JSON responses are indented for humans.
+func Negotiate(h goji.Handler) goji.Handler {
+	return goji.HandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		ctx = context.WithValue(ctx, responseFormatKey, NegotiateFormat(r.Header.Get("Accept")))
+		ctx = context.WithValue(ctx, prettyKey, r.URL.Query().Get("pretty") == "true")
+		h.ServeHTTPC(ctx, w, r)
+	})
+}
+
+// NegotiateFormat returns the supported media type with the highest
+// quality in the given Accept header value. Defaults to JSON.
+func NegotiateFormat(accept string) string {
+	format := MediaTypeJSON
+	best := 0.0
+
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+
+		var candidate string
+		switch mediaType {
+		case MediaTypeMsgpack, "application/x-msgpack":
+			candidate = MediaTypeMsgpack
+		case MediaTypeJSON, "application/*", "*/*":
+			candidate = MediaTypeJSON
+		default:
+			continue
+		}
+
+		if q > best {
+			format, best = candidate, q
+		}
+	}
+
+	return format
+}
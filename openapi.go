@@ -0,0 +1,268 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// OpenAPIHandler handles: GET /openapi.json.
+// Returns an OpenAPI 3 document describing the routes. It is generated on every
+// request so the collection enum always lists the current collections.
+func (d *DBController) OpenAPIHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	WriteResponse(ctx, w, http.StatusOK, d.OpenAPISpec())
+}
+
+// OpenAPISpec builds the OpenAPI 3 document from the routes (see Routes).
+func (d *DBController) OpenAPISpec() map[string]interface{} {
+	collections := d.DB.AllCols()
+	sort.Strings(collections)
+
+	paths := map[string]interface{}{}
+	for _, route := range d.Routes() {
+		path := openAPIPath(route.Path)
+		item, ok := paths[path].(map[string]interface{})
+		if !ok {
+			item = map[string]interface{}{}
+			paths[path] = item
+		}
+		item[strings.ToLower(route.Method)] = operation(route, collections)
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "crudmachine",
+			"version": Version,
+		},
+		"servers": []interface{}{
+			map[string]interface{}{"url": serverURL(d.BasePath)},
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": map[string]interface{}{
+				"Document": map[string]interface{}{
+					"type":                 "object",
+					"additionalProperties": true,
+				},
+				"Query": map[string]interface{}{
+					"description": "Tiedot query, see https://github.com/HouzuoGuo/tiedot/wiki/Query-processor-and-index",
+				},
+				"SearchResult": object(map[string]interface{}{
+					"results": array(ref("#/components/schemas/Document")),
+					"total":   ref("integer"),
+					"limit":   ref("integer"),
+					"offset":  ref("integer"),
+					"hasMore": ref("boolean"),
+				}),
+				"BulkResult": object(map[string]interface{}{
+					"ids":      array(ref("integer")),
+					"results":  array(ref("#/components/schemas/Document")),
+					"inserted": ref("integer"),
+					"updated":  ref("integer"),
+					"failed":   ref("integer"),
+					"errors":   array(ref("object")),
+					"dryRun":   ref("boolean"),
+				}),
+				"DeleteResult": object(map[string]interface{}{
+					"deleted": ref("integer"),
+					"failed":  ref("integer"),
+					"errors":  array(ref("object")),
+				}),
+				"Error": object(map[string]interface{}{
+					"error": object(map[string]interface{}{
+						"code":    ref("string"),
+						"message": ref("string"),
+					}),
+				}),
+			},
+		},
+	}
+}
+
+// openAPIPath converts the goji pattern to an OpenAPI path, e.g. /db/:collection to /db/{collection}.
+func openAPIPath(pattern string) string {
+	segments := strings.Split(pattern, "/")
+	for i, s := range segments {
+		if strings.HasPrefix(s, ":") {
+			segments[i] = "{" + s[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// operation describes the route with its path params and an error response.
+func operation(route Route, collections []string) map[string]interface{} {
+	params := []interface{}{}
+	for _, s := range strings.Split(route.Path, "/") {
+		switch s {
+		case ":collection":
+			params = append(params, collectionParam(collections))
+		case ":id":
+			params = append(params, idParam())
+		}
+	}
+	params = append(params, route.Op.Params...)
+
+	responses := map[string]interface{}{
+		"default": response("An error", ref("#/components/schemas/Error")),
+	}
+	for status, resp := range route.Op.Responses {
+		responses[strconv.Itoa(status)] = resp
+	}
+
+	op := map[string]interface{}{
+		"summary":   route.Op.Summary,
+		"responses": responses,
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+	if route.Op.Body != nil {
+		content := map[string]interface{}{}
+		for mediaType, schema := range route.Op.Body {
+			content[mediaType] = map[string]interface{}{"schema": schema}
+		}
+		op["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  content,
+		}
+	}
+	return op
+}
+
+// jsonBody returns the request body of an operation accepting JSON with the given schema.
+func jsonBody(schema interface{}) map[string]interface{} {
+	return map[string]interface{}{MediaTypeJSON: schema}
+}
+
+// streamResponse describes a response streamed in the given media type.
+func streamResponse(description, mediaType string) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"content": map[string]interface{}{
+			mediaType: map[string]interface{}{"schema": ref("string")},
+		},
+	}
+}
+
+// response describes a response with the given JSON schema. schema may be nil.
+func response(description string, schema interface{}) map[string]interface{} {
+	resp := map[string]interface{}{
+		"description": description,
+	}
+	if schema != nil {
+		resp["content"] = map[string]interface{}{
+			MediaTypeJSON: map[string]interface{}{"schema": schema},
+		}
+	}
+	return resp
+}
+
+// ref returns the schema of a primitive type or a reference to a component schema.
+func ref(s string) map[string]interface{} {
+	if len(s) > 0 && s[0] == '#' {
+		return map[string]interface{}{"$ref": s}
+	}
+	return map[string]interface{}{"type": s}
+}
+
+// object returns the schema of an object with the given properties.
+func object(properties map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+}
+
+// enum returns the schema of a string with the given values.
+func enum(values ...string) map[string]interface{} {
+	schema := ref("string")
+	schema["enum"] = values
+	return schema
+}
+
+// array returns the schema of an array with the given item schema.
+func array(items interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"type":  "array",
+		"items": items,
+	}
+}
+
+// collectionParam describes the collection path param with the known collections as enum.
+func collectionParam(collections []string) map[string]interface{} {
+	schema := ref("string")
+	if len(collections) > 0 {
+		schema["enum"] = collections
+	}
+	return map[string]interface{}{
+		"name":     "collection",
+		"in":       "path",
+		"required": true,
+		"schema":   schema,
+	}
+}
+
+// idParam describes the document id path param.
+func idParam() map[string]interface{} {
+	return map[string]interface{}{
+		"name":     "id",
+		"in":       "path",
+		"required": true,
+		"schema":   ref("integer"),
+	}
+}
+
+// queryParam describes an optional query param.
+func queryParam(name, typ, description string) map[string]interface{} {
+	return map[string]interface{}{
+		"name":        name,
+		"in":          "query",
+		"description": description,
+		"schema":      ref(typ),
+	}
+}
+
+// requiredQueryParam describes a query param that must be given.
+func requiredQueryParam(name, typ, description string) map[string]interface{} {
+	param := queryParam(name, typ, description)
+	param["required"] = true
+	return param
+}
+
+// headerParam describes an optional string header.
+func headerParam(name, description string) map[string]interface{} {
+	return map[string]interface{}{
+		"name":        name,
+		"in":          "header",
+		"description": description,
+		"schema":      ref("string"),
+	}
+}
+
+// dryRunParam describes the dryRun param of writes.
+func dryRunParam() map[string]interface{} {
+	return queryParam("dryRun", "boolean", "Only validate, nothing is written")
+}
+
+// returnPreviousParam describes the returnPrevious param of updates.
+func returnPreviousParam() map[string]interface{} {
+	return queryParam("returnPrevious", "boolean", "Also return the document before the update")
+}
+
+// ifMatchParam describes the If-Match header of updates.
+func ifMatchParam() map[string]interface{} {
+	return headerParam("If-Match", "ETag the current document must match, else 412 is returned")
+}
+
+// serverURL returns the URL of the server for the base path of the routes.
+func serverURL(basePath string) string {
+	if basePath == "" {
+		return "/"
+	}
+	return basePath
+}
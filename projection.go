@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// ParseFields parses the comma separated 'fields' query param of the request.
+// Returns nil if no fields were requested.
+func ParseFields(r *http.Request) []string {
+	v := r.URL.Query().Get("fields")
+	if v == "" {
+		return nil
+	}
+
+	fields := []string{}
+	for _, f := range strings.Split(v, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			fields = append(fields, f)
+		}
+	}
+
+	return fields
+}
+
+// ProjectDocument returns a copy of the document that only contains the given
+// top-level fields and the id stored in idField. Unknown fields are omitted.
+// If no fields are given the document is returned unchanged.
+func ProjectDocument(doc map[string]interface{}, fields []string, idField string) map[string]interface{} {
+	if len(fields) == 0 {
+		return doc
+	}
+
+	projected := map[string]interface{}{}
+	if id, ok := doc[idField]; ok && idField != "" {
+		projected[idField] = id
+	}
+
+	for _, f := range fields {
+		if v, ok := doc[f]; ok {
+			projected[f] = v
+		}
+	}
+
+	return projected
+}
+
+// HideFields returns a copy of the document without the top-level fields starting
+// with one of the prefixes. The id stored in idField is never hidden.
+// If no prefixes are given the document is returned unchanged.
+func HideFields(doc map[string]interface{}, prefixes []string, idField string) map[string]interface{} {
+	if len(prefixes) == 0 {
+		return doc
+	}
+
+	visible := make(map[string]interface{}, len(doc))
+	for k, v := range doc {
+		if k != idField && hasAnyPrefix(k, prefixes) {
+			continue
+		}
+		visible[k] = v
+	}
+
+	return visible
+}
+
+// hasAnyPrefix reports whether s starts with one of the prefixes.
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// ResponseDocument prepares a document of the collection for a read response.
+// Hidden fields of the collection are removed unless ?raw=true is given and
+// the result is reduced to the fields requested with 'fields' (see ParseFields).
+func (d *DBController) ResponseDocument(collName string, r *http.Request, doc map[string]interface{}) map[string]interface{} {
+	return ProjectDocument(HideFields(doc, d.hiddenPrefixes(collName, r), d.IDField), ParseFields(r), d.IDField)
+}
+
+// ResponseDocuments applies ResponseDocument to every document in the list.
+func (d *DBController) ResponseDocuments(collName string, r *http.Request, docs []interface{}) []interface{} {
+	for i, doc := range docs {
+		if m, ok := doc.(map[string]interface{}); ok {
+			docs[i] = d.ResponseDocument(collName, r, m)
+		}
+	}
+
+	return docs
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"goji.io"
+	"golang.org/x/net/context"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// rateLimiterIdle is the time after which the limiter of an inactive client is dropped.
+	rateLimiterIdle = 3 * time.Minute
+	// rateLimiterCleanup is the interval in which inactive limiters are dropped.
+	rateLimiterCleanup = time.Minute
+)
+
+// RateLimitExempt lists the paths that are never rate limited.
+var RateLimitExempt = map[string]bool{
+	"/health":  true,
+	"/ready":   true,
+	"/version": true,
+}
+
+// clientLimiter is the token bucket of a single client.
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// RateLimiter limits the request rate per client IP with token buckets.
+type RateLimiter struct {
+	rps   rate.Limit
+	burst int
+
+	mu          sync.Mutex
+	clients     map[string]*clientLimiter
+	lastCleanup time.Time
+}
+
+// NewRateLimiter creates a RateLimiter allowing rps requests per second
+// with bursts of up to burst requests for every client.
+func NewRateLimiter(rps float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		rps:         rate.Limit(rps),
+		burst:       burst,
+		clients:     map[string]*clientLimiter{},
+		lastCleanup: time.Now(),
+	}
+}
+
+// limiter returns the limiter of the client and drops inactive ones from time to time.
+func (l *RateLimiter) limiter(client string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastCleanup) > rateLimiterCleanup {
+		for k, c := range l.clients {
+			if now.Sub(c.lastSeen) > rateLimiterIdle {
+				delete(l.clients, k)
+			}
+		}
+		l.lastCleanup = now
+	}
+
+	c, ok := l.clients[client]
+	if !ok {
+		c = &clientLimiter{limiter: rate.NewLimiter(l.rps, l.burst)}
+		l.clients[client] = c
+	}
+	c.lastSeen = now
+
+	return c.limiter
+}
+
+// Middleware rejects requests of clients exceeding their rate with 429
+// and a Retry-After header.
+func (l *RateLimiter) Middleware(h goji.Handler) goji.Handler {
+	return goji.HandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		if RateLimitExempt[r.URL.Path] {
+			h.ServeHTTPC(ctx, w, r)
+			return
+		}
+
+		res := l.limiter(ClientIP(r)).Reserve()
+		if delay := res.Delay(); !res.OK() || delay > 0 {
+			res.Cancel()
+			if res.OK() {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			}
+			WriteError(ctx, w, http.StatusTooManyRequests, CodeRateLimited, "too many requests")
+			return
+		}
+
+		h.ServeHTTPC(ctx, w, r)
+	})
+}
+
+// ClientIP returns the IP address of the client that sent the request.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
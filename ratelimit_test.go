@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"goji.io"
+	"goji.io/pat"
+	"golang.org/x/net/context"
+)
+
+func TestRateLimiterMiddleware(t *testing.T) {
+	// One request every 100 seconds leaves only the burst within the test.
+	l := NewRateLimiter(0.01, 2)
+
+	mux := goji.NewMux()
+	mux.UseC(l.Middleware)
+	ok := func(ctx context.Context, w http.ResponseWriter, r *http.Request) {}
+	mux.HandleFuncC(pat.Get("/db/books"), ok)
+	mux.HandleFuncC(pat.Get("/health"), ok)
+
+	// The steps share the limiter, so their order matters.
+	steps := []struct {
+		client string
+		path   string
+		want   int
+	}{
+		{client: "10.0.0.1:5000", path: "/db/books", want: http.StatusOK},
+		{client: "10.0.0.1:5001", path: "/db/books", want: http.StatusOK},
+		{client: "10.0.0.1:5002", path: "/db/books", want: http.StatusTooManyRequests},
+		{client: "10.0.0.1:5003", path: "/health", want: http.StatusOK},
+		{client: "10.0.0.2:5000", path: "/db/books", want: http.StatusOK},
+		{client: "10.0.0.1:5004", path: "/db/books", want: http.StatusTooManyRequests},
+	}
+
+	for i, s := range steps {
+		r := httptest.NewRequest("GET", s.path, nil)
+		r.RemoteAddr = s.client
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+
+		if w.Code != s.want {
+			t.Errorf("step %d: %s %s: status = %d, want %d", i, s.client, s.path, w.Code, s.want)
+		}
+		if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+			t.Errorf("step %d: Retry-After is missing", i)
+		}
+	}
+}
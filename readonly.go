@@ -0,0 +1,22 @@
+package main
+
+import (
+	"net/http"
+
+	"goji.io"
+	"golang.org/x/net/context"
+)
+
+// Writable wraps a handler that modifies the database. In read-only mode the
+// handler is not called and 405 is returned instead, so reads keep working
+// while the data is frozen.
+func (d *DBController) Writable(h goji.HandlerFunc) goji.HandlerFunc {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		if d.ReadOnly {
+			w.Header().Set("Allow", "GET, HEAD")
+			WriteError(ctx, w, http.StatusMethodNotAllowed, CodeReadOnly, "server is in read-only mode")
+			return
+		}
+		h(ctx, w, r)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadOnly(t *testing.T) {
+	d := newTestController(t)
+	if err := d.DB.Create("books"); err != nil {
+		t.Fatal(err)
+	}
+	id := createDocument(t, d, "books", `{"title": "Dune", "edition": 1}`)
+	before := snapshot(d, "books")
+	d.ReadOnly = true
+
+	// POST routes that only read.
+	reads := map[string]bool{
+		"/db/:collection/mget":      true,
+		"/db/:collection/aggregate": true,
+		"/db/:collection/groupby":   true,
+		"/db/:collection/explain":   true,
+		"/db/search/:collection":    true,
+	}
+
+	for _, route := range d.Routes() {
+		if route.Method == http.MethodGet || route.Method == http.MethodHead || reads[route.Path] {
+			if route.Writable {
+				t.Errorf("%s %s is marked writable but only reads", route.Method, route.Path)
+			}
+			continue
+		}
+		if !route.Writable {
+			t.Errorf("%s %s modifies the database but is not marked writable", route.Method, route.Path)
+			continue
+		}
+
+		path := strings.NewReplacer(":collection", "books", ":id", id).Replace(route.Path)
+		w := serve(t, d, route.Method, path, `{"title": "Emma", "edition": 1}`)
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("%s %s: status = %d, want %d", route.Method, path, w.Code, http.StatusMethodNotAllowed)
+		}
+		if got := w.Header().Get("Allow"); got != "GET, HEAD" {
+			t.Errorf("%s %s: Allow = %q, want GET, HEAD", route.Method, path, got)
+		}
+	}
+
+	if w := serve(t, d, "GET", "/db/books/"+id, ""); w.Code != http.StatusOK {
+		t.Errorf("GET: status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if after := snapshot(d, "books"); !reflect.DeepEqual(after, before) {
+		t.Errorf("documents = %v, want %v", after, before)
+	}
+	if got := collections(d); !reflect.DeepEqual(got, []string{"books"}) {
+		t.Errorf("collections = %v, want [books]", got)
+	}
+}
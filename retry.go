@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// RetryPolicy controls how failed database writes are retried.
+// The zero value does not retry.
+type RetryPolicy struct {
+	// Retries is the maximum number of retries after the first attempt.
+	Retries int
+	// Backoff is the wait before the first retry. It doubles with every further retry.
+	Backoff time.Duration
+}
+
+// Do calls write until it succeeds, fails with a permanent error or all retries
+// are used up. The last error is returned. Every retry is logged with op.
+func (p RetryPolicy) Do(op string, write func() error) error {
+	backoff := p.Backoff
+	for attempt := 1; ; attempt++ {
+		err := write()
+		if err == nil || attempt > p.Retries || !transientError(err) {
+			return err
+		}
+
+		slog.Warn("database write failed, retrying", "op", op, "attempt", attempt, "backoff", backoff, "error", err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// transientError reports whether a failed write may succeed when it is retried.
+// Constraint violations and missing documents or collections never do.
+func transientError(err error) bool {
+	var uniqueErr *UniqueError
+	if errors.As(err, &uniqueErr) || errors.Is(err, ErrCollectionNotFound) {
+		return false
+	}
+	// Tiedot reports missing documents with untyped errors.
+	return !strings.Contains(err.Error(), "does not exist")
+}
@@ -0,0 +1,520 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"goji.io"
+	"goji.io/pat"
+)
+
+// Route is a route of the API together with the description of its operation
+// in the OpenAPI document. All routes are registered from Routes, so the
+// OpenAPI document always lists every route.
+type Route struct {
+	Method string
+	// Path is the goji pattern of the route, e.g. /db/:collection/:id.
+	Path    string
+	Handler goji.HandlerFunc
+	// Writable marks routes that modify the database, see DBController.Writable.
+	Writable bool
+	Op       Operation
+}
+
+// Operation describes a route in the OpenAPI document.
+// Path params are added from the route path.
+type Operation struct {
+	Summary string
+	// Params holds the query and header params.
+	Params []interface{}
+	// Body maps the accepted media types of the request body to their schema.
+	// nil means the route has no request body.
+	Body map[string]interface{}
+	// Responses maps the success status codes to their response.
+	// Error responses are added for all routes.
+	Responses map[int]interface{}
+}
+
+// Pattern returns the goji pattern matching the method and path of the route.
+func (r Route) Pattern() *pat.Pattern {
+	switch r.Method {
+	case http.MethodGet:
+		return pat.Get(r.Path)
+	case http.MethodHead:
+		return pat.Head(r.Path)
+	case http.MethodPost:
+		return pat.Post(r.Path)
+	case http.MethodPut:
+		return pat.Put(r.Path)
+	case http.MethodPatch:
+		return pat.Patch(r.Path)
+	case http.MethodDelete:
+		return pat.Delete(r.Path)
+	default:
+		panic("unsupported method " + r.Method)
+	}
+}
+
+// AllowedMethods returns the comma separated methods of the routes per path
+// in the order the paths first appear. GET routes also serve HEAD.
+func AllowedMethods(routes []Route) (paths []string, allow map[string]string) {
+	methods := map[string][]string{}
+	for _, r := range routes {
+		if _, ok := methods[r.Path]; !ok {
+			paths = append(paths, r.Path)
+		}
+		methods[r.Path] = appendMethod(methods[r.Path], r.Method)
+		if r.Method == http.MethodGet {
+			methods[r.Path] = appendMethod(methods[r.Path], http.MethodHead)
+		}
+	}
+
+	allow = map[string]string{}
+	for path, m := range methods {
+		allow[path] = strings.Join(m, ", ")
+	}
+	return paths, allow
+}
+
+// appendMethod appends the method unless it is already contained.
+func appendMethod(methods []string, method string) []string {
+	for _, m := range methods {
+		if m == method {
+			return methods
+		}
+	}
+	return append(methods, method)
+}
+
+// Routes returns all routes of the API. Routes match in order,
+// so more specific paths must come before paths with params in their place.
+// Searches come last so collection routes win for collections named 'search'.
+func (d *DBController) Routes() []Route {
+	documentBody := jsonBody(ref("#/components/schemas/Document"))
+	queryBody := jsonBody(object(map[string]interface{}{
+		"query": ref("#/components/schemas/Query"),
+	}))
+	indexBody := jsonBody(object(map[string]interface{}{
+		"path": array(ref("string")),
+	}))
+	documentResponse := response("The document", ref("#/components/schemas/Document"))
+	updateResponse := response("The stored document, with returnPrevious=true wrapped together with the previous version",
+		ref("#/components/schemas/Document"))
+	pageResponse := response("A page of documents", ref("#/components/schemas/SearchResult"))
+	deleteResponse := response("The number of deleted documents", ref("#/components/schemas/DeleteResult"))
+	collectionResponse := response("The collection", object(map[string]interface{}{
+		"collection": ref("string"),
+	}))
+
+	return []Route{
+		{
+			Method: http.MethodGet, Path: "/health", Handler: d.HealthHandler,
+			Op: Operation{
+				Summary: "Check that the server is up",
+				Responses: map[int]interface{}{http.StatusOK: response("The server is up", object(map[string]interface{}{
+					"status":   ref("string"),
+					"readonly": ref("boolean"),
+				}))},
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/ready", Handler: d.ReadyHandler,
+			Op: Operation{
+				Summary: "Check that the database is usable",
+				Responses: map[int]interface{}{http.StatusOK: response("The database is usable", object(map[string]interface{}{
+					"status": ref("string"),
+				}))},
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/version", Handler: VersionHandler,
+			Op: Operation{
+				Summary: "Read the build information",
+				Responses: map[int]interface{}{http.StatusOK: response("The build information", object(map[string]interface{}{
+					"version":   ref("string"),
+					"commit":    ref("string"),
+					"buildTime": ref("string"),
+					"go":        ref("string"),
+				}))},
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/openapi.json", Handler: d.OpenAPIHandler,
+			Op: Operation{
+				Summary:   "Read this OpenAPI document",
+				Responses: map[int]interface{}{http.StatusOK: response("The OpenAPI document", ref("object"))},
+			},
+		},
+
+		{
+			Method: http.MethodPost, Path: "/db/:collection/bulk", Handler: d.BulkCreateDocumentsHandler, Writable: true,
+			Op: Operation{
+				Summary:   "Create multiple documents",
+				Params:    []interface{}{dryRunParam()},
+				Body:      jsonBody(array(ref("#/components/schemas/Document"))),
+				Responses: map[int]interface{}{http.StatusOK: response("The results per document", ref("#/components/schemas/BulkResult"))},
+			},
+		},
+		{
+			Method: http.MethodPost, Path: "/db/:collection/batch-update", Handler: d.BatchUpdateDocumentsHandler, Writable: true,
+			Op: Operation{
+				Summary: "Patch multiple documents",
+				Params:  []interface{}{dryRunParam()},
+				Body: jsonBody(array(object(map[string]interface{}{
+					"id":    ref("integer"),
+					"patch": ref("#/components/schemas/Document"),
+				}))),
+				Responses: map[int]interface{}{http.StatusOK: response("The results per document", ref("#/components/schemas/BulkResult"))},
+			},
+		},
+		{
+			Method: http.MethodPost, Path: "/db/:collection/import", Handler: d.ImportCollectionHandler, Writable: true,
+			Op: Operation{
+				Summary:   "Import newline delimited JSON documents",
+				Body:      map[string]interface{}{"application/x-ndjson": ref("string")},
+				Responses: map[int]interface{}{http.StatusOK: response("The number of imported documents and the failed lines", ref("#/components/schemas/BulkResult"))},
+			},
+		},
+		{
+			Method: http.MethodPost, Path: "/db/:collection/delete", Handler: d.DeleteByQueryHandler, Writable: true,
+			Op: Operation{
+				Summary:   "Delete all documents matching a Tiedot query",
+				Params:    []interface{}{queryParam("hard", "boolean", "Remove the documents permanently in collections with soft deletes")},
+				Body:      queryBody,
+				Responses: map[int]interface{}{http.StatusOK: deleteResponse},
+			},
+		},
+		{
+			Method: http.MethodPost, Path: "/db/:collection/mget", Handler: d.MultiReadDocumentsHandler,
+			Op: Operation{
+				Summary: "Read multiple documents",
+				Body: jsonBody(object(map[string]interface{}{
+					"ids": array(ref("integer")),
+				})),
+				Responses: map[int]interface{}{http.StatusOK: response("The documents in request order", object(map[string]interface{}{
+					"results": array(ref("#/components/schemas/Document")),
+					"errors":  array(ref("object")),
+				}))},
+			},
+		},
+		{
+			Method: http.MethodPost, Path: "/db/:collection/scrub", Handler: d.ScrubCollectionHandler, Writable: true,
+			Op: Operation{
+				Summary:   "Repair and defragment the collection",
+				Responses: map[int]interface{}{http.StatusOK: collectionResponse},
+			},
+		},
+		{
+			Method: http.MethodPost, Path: "/db/:collection/truncate", Handler: d.TruncateCollectionHandler, Writable: true,
+			Op: Operation{
+				Summary:   "Delete all documents but keep the collection",
+				Responses: map[int]interface{}{http.StatusOK: deleteResponse},
+			},
+		},
+		{
+			Method: http.MethodPost, Path: "/db/:collection/aggregate", Handler: d.AggregateHandler,
+			Op: Operation{
+				Summary: "Aggregate a numeric field",
+				Body: jsonBody(object(map[string]interface{}{
+					"field": ref("string"),
+					"op":    enum("sum", "avg", "min", "max", "count"),
+					"query": ref("#/components/schemas/Query"),
+				})),
+				Responses: map[int]interface{}{http.StatusOK: response("The aggregated value", object(map[string]interface{}{
+					"field": ref("string"),
+					"op":    ref("string"),
+					"value": ref("number"),
+					"count": ref("integer"),
+				}))},
+			},
+		},
+		{
+			Method: http.MethodPost, Path: "/db/:collection/groupby", Handler: d.GroupByHandler,
+			Op: Operation{
+				Summary: "Count documents per value of a field",
+				Body: jsonBody(object(map[string]interface{}{
+					"field": ref("string"),
+					"query": ref("#/components/schemas/Query"),
+				})),
+				Responses: map[int]interface{}{http.StatusOK: response("The counts per value", object(map[string]interface{}{
+					"field":  ref("string"),
+					"groups": map[string]interface{}{"type": "object", "additionalProperties": ref("integer")},
+					"total":  ref("integer"),
+				}))},
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/db/:collection/count", Handler: d.CountDocumentsHandler,
+			Op: Operation{
+				Summary: "Count the documents of the collection",
+				Params: []interface{}{
+					queryParam("approx", "boolean", "Return a fast approximation"),
+					queryParam("includeDeleted", "boolean", "Count soft-deleted documents"),
+				},
+				Responses: map[int]interface{}{http.StatusOK: response("The document count", object(map[string]interface{}{
+					"count":  ref("integer"),
+					"approx": ref("boolean"),
+				}))},
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/db/:collection/export", Handler: d.ExportCollectionHandler,
+			Op: Operation{
+				Summary:   "Export all documents as newline delimited JSON",
+				Responses: map[int]interface{}{http.StatusOK: streamResponse("One document per line", "application/x-ndjson")},
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/db/:collection/distinct", Handler: d.DistinctValuesHandler,
+			Op: Operation{
+				Summary: "List the distinct values of a field",
+				Params: []interface{}{
+					requiredQueryParam("field", "string", "Top-level field"),
+					queryParam("limit", "integer", "Maximum number of values"),
+				},
+				Responses: map[int]interface{}{http.StatusOK: response("The sorted values", object(map[string]interface{}{
+					"field":  ref("string"),
+					"values": array(map[string]interface{}{}),
+					"total":  ref("integer"),
+				}))},
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/db/:collection/search", Handler: d.TextSearchHandler,
+			Op: Operation{
+				Summary: "Search documents containing a term",
+				Params: []interface{}{
+					requiredQueryParam("q", "string", "Case-insensitive term"),
+					queryParam("limit", "integer", "Maximum number of documents"),
+					queryParam("offset", "integer", "Number of documents to skip"),
+				},
+				Responses: map[int]interface{}{http.StatusOK: pageResponse},
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/db/:collection/watch", Handler: d.WatchCollectionHandler,
+			Op: Operation{
+				Summary:   "Watch changes of the collection over a WebSocket",
+				Responses: map[int]interface{}{http.StatusSwitchingProtocols: response("A JSON encoded event is sent for every change", nil)},
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/db/:collection/events", Handler: d.EventStreamHandler,
+			Op: Operation{
+				Summary:   "Stream changes of the collection as server-sent events",
+				Responses: map[int]interface{}{http.StatusOK: streamResponse("A data line with a JSON encoded event for every change", "text/event-stream")},
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/db/:collection/index", Handler: d.ReadIndexesHandler,
+			Op: Operation{
+				Summary: "List the indexes of the collection",
+				Responses: map[int]interface{}{http.StatusOK: response("The indexed paths", object(map[string]interface{}{
+					"indexes": array(array(ref("string"))),
+				}))},
+			},
+		},
+		{
+			Method: http.MethodPost, Path: "/db/:collection/index", Handler: d.CreateIndexHandler, Writable: true,
+			Op: Operation{
+				Summary:   "Create an index",
+				Body:      indexBody,
+				Responses: map[int]interface{}{http.StatusCreated: response("The index was created", indexBody[MediaTypeJSON])},
+			},
+		},
+		{
+			Method: http.MethodDelete, Path: "/db/:collection/index", Handler: d.DeleteIndexHandler, Writable: true,
+			Op: Operation{
+				Summary:   "Remove an index",
+				Body:      indexBody,
+				Responses: map[int]interface{}{http.StatusOK: response("The index was removed", indexBody[MediaTypeJSON])},
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/db/:collection/indexes", Handler: d.ListIndexesHandler,
+			Op: Operation{
+				Summary: "List the indexes of the collection with hints on their usage",
+				Responses: map[int]interface{}{http.StatusOK: response("The indexes and the checked fields without index", object(map[string]interface{}{
+					"indexes": array(object(map[string]interface{}{
+						"path":   array(ref("string")),
+						"filter": ref("string"),
+						"query":  ref("#/components/schemas/Query"),
+						"unique": ref("boolean"),
+						"key":    ref("boolean"),
+					})),
+					"missing": array(array(ref("string"))),
+				}))},
+			},
+		},
+		{
+			Method: http.MethodPost, Path: "/db/:collection/explain", Handler: d.ExplainQueryHandler,
+			Op: Operation{
+				Summary: "Report which indexes a Tiedot query uses",
+				Body:    queryBody,
+				Responses: map[int]interface{}{http.StatusOK: response("The query plan", object(map[string]interface{}{
+					"indexes":  array(array(ref("string"))),
+					"used":     array(array(ref("string"))),
+					"missing":  array(array(ref("string"))),
+					"fullScan": ref("boolean"),
+				}))},
+			},
+		},
+
+		{
+			Method: http.MethodDelete, Path: "/db/:collection/:id/hard", Handler: d.HardDeleteDocumentHandler, Writable: true,
+			Op: Operation{
+				Summary:   "Delete a document permanently",
+				Responses: map[int]interface{}{http.StatusOK: response("The document was deleted", nil)},
+			},
+		},
+		{
+			Method: http.MethodPost, Path: "/db/:collection/:id/incr", Handler: d.IncrementDocumentHandler, Writable: true,
+			Op: Operation{
+				Summary: "Increment a numeric field of a document",
+				Body: jsonBody(object(map[string]interface{}{
+					"field": ref("string"),
+					"by":    ref("number"),
+				})),
+				Responses: map[int]interface{}{http.StatusOK: response("The updated document", ref("#/components/schemas/Document"))},
+			},
+		},
+		{
+			Method: http.MethodHead, Path: "/db/:collection/:id", Handler: d.ReadDocumentHandler,
+			Op: Operation{
+				Summary:   "Check that a document exists",
+				Responses: map[int]interface{}{http.StatusOK: response("The document exists", nil)},
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/db/:collection/:id", Handler: d.ReadDocumentHandler,
+			Op: Operation{
+				Summary: "Read a document",
+				Params: []interface{}{
+					queryParam("fields", "string", "Comma separated fields to return"),
+					queryParam("raw", "boolean", "Include hidden fields"),
+					queryParam("includeDeleted", "boolean", "Also return a soft-deleted document"),
+					headerParam("If-None-Match", "ETag of a cached version, 304 is returned if it is current"),
+				},
+				Responses: map[int]interface{}{
+					http.StatusOK:          documentResponse,
+					http.StatusNotModified: response("The cached version is current", nil),
+				},
+			},
+		},
+		{
+			Method: http.MethodPut, Path: "/db/:collection/:id", Handler: d.UpdateDocumentHandler, Writable: true,
+			Op: Operation{
+				Summary: "Replace or create a document",
+				Params:  []interface{}{dryRunParam(), returnPreviousParam(), ifMatchParam()},
+				Body:    documentBody,
+				Responses: map[int]interface{}{
+					http.StatusOK:      updateResponse,
+					http.StatusCreated: response("The created document", ref("#/components/schemas/Document")),
+				},
+			},
+		},
+		{
+			Method: http.MethodPatch, Path: "/db/:collection/:id", Handler: d.PatchDocumentHandler, Writable: true,
+			Op: Operation{
+				Summary: "Partially update a document",
+				Params:  []interface{}{dryRunParam(), returnPreviousParam(), ifMatchParam()},
+				Body: map[string]interface{}{
+					MediaTypeJSON:                  ref("#/components/schemas/Document"),
+					"application/merge-patch+json": ref("#/components/schemas/Document"),
+					MediaTypeJSONPatch: array(object(map[string]interface{}{
+						"op":    enum("add", "remove", "replace", "move", "copy", "test"),
+						"path":  ref("string"),
+						"from":  ref("string"),
+						"value": map[string]interface{}{},
+					})),
+				},
+				Responses: map[int]interface{}{http.StatusOK: updateResponse},
+			},
+		},
+		{
+			Method: http.MethodDelete, Path: "/db/:collection/:id", Handler: d.DeleteDocumentHandler, Writable: true,
+			Op: Operation{
+				Summary:   "Delete a document, or mark it deleted in collections with soft deletes",
+				Responses: map[int]interface{}{http.StatusOK: response("The document was deleted", nil)},
+			},
+		},
+
+		{
+			Method: http.MethodGet, Path: "/db/:collection", Handler: d.ReadCollectionHandler,
+			Op: Operation{
+				Summary: "Read the documents of the collection",
+				Params: []interface{}{
+					queryParam("limit", "integer", "Maximum number of documents"),
+					queryParam("offset", "integer", "Number of documents to skip"),
+					queryParam("all", "boolean", "Return all documents instead of the default limit"),
+					queryParam("sort", "string", "Comma separated fields to sort by"),
+					queryParam("order", "string", "Comma separated sort orders: asc or desc"),
+					queryParam("fields", "string", "Comma separated fields to return"),
+					queryParam("q", "string", "OR filter of the form path:value|path:value"),
+					queryParam("updatedSince", "string", "RFC3339 timestamp, only documents updated after it"),
+					queryParam("includeDeleted", "boolean", "Include soft-deleted documents"),
+					queryParam("raw", "boolean", "Include hidden fields"),
+				},
+				Responses: map[int]interface{}{http.StatusOK: pageResponse},
+			},
+		},
+		{
+			Method: http.MethodPost, Path: "/db/:collection", Handler: d.CreateDocumentHandler, Writable: true,
+			Op: Operation{
+				Summary: "Create a document, or multiple documents from an array",
+				Params: []interface{}{
+					dryRunParam(),
+					headerParam("If-None-Match", "* creates the document only if no document has the same key"),
+				},
+				Body: documentBody,
+				Responses: map[int]interface{}{
+					http.StatusCreated: response("The created document", ref("#/components/schemas/Document")),
+					http.StatusOK: response("The results per document for arrays, the validated document for dry runs", map[string]interface{}{
+						"oneOf": []interface{}{ref("#/components/schemas/BulkResult"), ref("#/components/schemas/Document")},
+					}),
+				},
+			},
+		},
+		{
+			Method: http.MethodDelete, Path: "/db/:collection", Handler: d.DeleteCollectionHandler, Writable: true,
+			Op: Operation{
+				Summary:   "Drop the collection",
+				Responses: map[int]interface{}{http.StatusOK: collectionResponse},
+			},
+		},
+
+		{
+			Method: http.MethodGet, Path: "/db", Handler: d.ListCollectionsHandler,
+			Op: Operation{
+				Summary: "List all collections",
+				Responses: map[int]interface{}{http.StatusOK: response("The collections with their approximate document counts", array(object(map[string]interface{}{
+					"name":  ref("string"),
+					"count": ref("integer"),
+				})))},
+			},
+		},
+		{
+			Method: http.MethodPost, Path: "/db", Handler: d.CreateCollectionHandler, Writable: true,
+			Op: Operation{
+				Summary: "Create a collection",
+				Body: jsonBody(object(map[string]interface{}{
+					"collection": ref("string"),
+				})),
+				Responses: map[int]interface{}{http.StatusCreated: collectionResponse},
+			},
+		},
+
+		{
+			Method: http.MethodPost, Path: "/db/search/:collection", Handler: d.SearchCollectionHandler,
+			Op: Operation{
+				Summary: "Search documents with a Tiedot query",
+				Params: []interface{}{
+					queryParam("idsOnly", "boolean", "Only return the ids of the matching documents"),
+					queryParam("includeDeleted", "boolean", "Include soft-deleted documents"),
+				},
+				Body:      queryBody,
+				Responses: map[int]interface{}{http.StatusOK: pageResponse},
+			},
+		},
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+
+	"golang.org/x/net/context"
+)
+
+// Schema is a small subset of JSON schema used to validate documents.
+// Example:
+//
+//	{
+//	  "required": ["name"],
+//	  "properties": {
+//	    "name": {"type": "string"},
+//	    "age": {"type": "integer"},
+//	    "tags": {"type": "array", "items": {"type": "string"}}
+//	  }
+//	}
+type Schema struct {
+	// Type is one of: string, number, integer, boolean, object, array or null.
+	// An empty type allows any value.
+	Type string `json:"type"`
+	// Required lists the keys an object must contain.
+	Required []string `json:"required"`
+	// Properties holds the schemas of the object keys.
+	Properties map[string]*Schema `json:"properties"`
+	// Items is the schema of all array elements.
+	Items *Schema `json:"items"`
+}
+
+// LoadSchema reads and parses the schema file at the given path.
+func LoadSchema(path string) (*Schema, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	schema := &Schema{}
+	if err := json.Unmarshal(b, schema); err != nil {
+		return nil, fmt.Errorf("could not parse schema %s: %w", path, err)
+	}
+
+	return schema, nil
+}
+
+// Validate checks the value against the schema and returns all violations.
+// The path is used to prefix the violations and should be empty for documents.
+func (s *Schema) Validate(v interface{}, path string) []string {
+	violations := []string{}
+
+	if s.Type != "" && !hasType(v, s.Type) {
+		return append(violations, fieldPath(path)+": must be of type "+s.Type)
+	}
+
+	if obj, ok := v.(map[string]interface{}); ok {
+		for _, k := range s.Required {
+			if _, ok := obj[k]; !ok {
+				violations = append(violations, joinPath(path, k)+": is required")
+			}
+		}
+
+		keys := make([]string, 0, len(s.Properties))
+		for k := range s.Properties {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			if fv, ok := obj[k]; ok {
+				violations = append(violations, s.Properties[k].Validate(fv, joinPath(path, k))...)
+			}
+		}
+	}
+
+	if arr, ok := v.([]interface{}); ok && s.Items != nil {
+		for i, e := range arr {
+			violations = append(violations, s.Items.Validate(e, fmt.Sprintf("%s[%d]", fieldPath(path), i))...)
+		}
+	}
+
+	return violations
+}
+
+// hasType reports whether the decoded JSON value is of the given schema type.
+func hasType(v interface{}, typ string) bool {
+	switch typ {
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	case "integer":
+		f, ok := v.(float64)
+		return ok && f == math.Trunc(f)
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "object":
+		_, ok := v.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := v.([]interface{})
+		return ok
+	case "null":
+		return v == nil
+	}
+	return false
+}
+
+// joinPath appends the key to the dotted field path.
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// fieldPath returns a printable name for the field path.
+func fieldPath(path string) string {
+	if path == "" {
+		return "document"
+	}
+	return path
+}
+
+// ValidateDocument checks the structure limits of the document (see CheckStructure)
+// and validates it against the schema of the collection.
+// Collections without a schema accept every document within the limits.
+func (d *DBController) ValidateDocument(collName string, doc map[string]interface{}) []string {
+	if violations := d.CheckStructure(doc); len(violations) > 0 {
+		return violations
+	}
+
+	schema := d.CollectionConfig(collName).Schema
+	if schema == nil {
+		return nil
+	}
+	return schema.Validate(doc, "")
+}
+
+// WriteSchemaViolations writes a 422 response listing the schema or structure violations.
+func WriteSchemaViolations(ctx context.Context, w http.ResponseWriter, violations []string) {
+	WriteResponse(ctx, w, http.StatusUnprocessableEntity, map[string]interface{}{
+		"error": SchemaViolationError(violations),
+	})
+}
+
+// SchemaViolationError returns the error object for a document violating the schema or structure limits.
+func SchemaViolationError(violations []string) map[string]interface{} {
+	e := NewError(CodeSchemaViolation, "document does not match the collection schema or document limits")
+	e["violations"] = violations
+	return e
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSchemaValidation(t *testing.T) {
+	d := newTestController(t)
+
+	// The schema path is relative to the directory of the config file.
+	cfg := writeConfig(t, "users:users.schema.json\n")
+	schema := `{
+		"required": ["name"],
+		"properties": {
+			"name": {"type": "string"},
+			"age": {"type": "integer"},
+			"tags": {"type": "array", "items": {"type": "string"}}
+		}
+	}`
+	if err := os.WriteFile(filepath.Join(filepath.Dir(cfg), "users.schema.json"), []byte(schema), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetupCollections(cfg, SetupOptions{}); err != nil {
+		t.Fatalf("SetupCollections() error = %v", err)
+	}
+
+	id := createDocument(t, d, "users", `{"name": "alice", "age": 30}`)
+
+	tests := []struct {
+		method         string
+		path           string
+		body           string
+		wantViolations []string
+	}{
+		{
+			method:         "POST",
+			path:           "/db/users",
+			body:           `{"age": 30.5, "tags": ["a", 1]}`,
+			wantViolations: []string{"name: is required", "age: must be of type integer", "tags[1]: must be of type string"},
+		},
+		{
+			method:         "PUT",
+			path:           "/db/users/" + id,
+			body:           `{"age": 31}`,
+			wantViolations: []string{"name: is required"},
+		},
+		{
+			method:         "PATCH",
+			path:           "/db/users/" + id,
+			body:           `{"name": 42}`,
+			wantViolations: []string{"name: must be of type string"},
+		},
+	}
+
+	for _, tt := range tests {
+		w := serve(t, d, tt.method, tt.path, tt.body)
+		if w.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s %s: status = %d, want %d", tt.method, tt.path, w.Code, http.StatusUnprocessableEntity)
+			continue
+		}
+
+		e, _ := decode(t, w)["error"].(map[string]interface{})
+		got := []string{}
+		for _, v := range e["violations"].([]interface{}) {
+			got = append(got, v.(string))
+		}
+		if !reflect.DeepEqual(got, tt.wantViolations) {
+			t.Errorf("%s %s: violations = %q, want %q", tt.method, tt.path, got, tt.wantViolations)
+		}
+	}
+
+	if got := decode(t, serve(t, d, "GET", "/db/users/"+id, ""))["name"]; got != "alice" {
+		t.Errorf("name = %v, want alice", got)
+	}
+}
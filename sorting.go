@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// SortKey is a top-level field documents are ordered by.
+type SortKey struct {
+	Field string
+	Desc  bool
+}
+
+// ParseSort parses the 'sort' and 'order' query params of the request.
+// Both take comma separated lists, e.g. ?sort=lastName,firstName&order=asc,desc,
+// where documents are ordered by the first field and ties are broken by the following ones.
+// Each order must be either 'asc' or 'desc' and the number of orders must match
+// the number of fields. Without 'order' all fields are sorted ascending.
+func ParseSort(r *http.Request) ([]SortKey, error) {
+	query := r.URL.Query()
+
+	sortParam := query.Get("sort")
+	if sortParam == "" {
+		return nil, nil
+	}
+
+	fields := strings.Split(sortParam, ",")
+	var orders []string
+	if o := query.Get("order"); o != "" {
+		orders = strings.Split(o, ",")
+		if len(orders) != len(fields) {
+			return nil, fmt.Errorf("order must contain one entry per sort field")
+		}
+	}
+
+	keys := make([]SortKey, len(fields))
+	for i, f := range fields {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			return nil, fmt.Errorf("sort must not contain empty fields")
+		}
+		keys[i].Field = f
+
+		if orders == nil {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(orders[i])) {
+		case "asc":
+			keys[i].Desc = false
+		case "desc":
+			keys[i].Desc = true
+		default:
+			return nil, fmt.Errorf("order must be either asc or desc")
+		}
+	}
+
+	return keys, nil
+}
+
+// ParseSortOption parses the sort option of the collections config file, a comma
+// separated list of fields each optionally followed by ':asc' or ':desc',
+// e.g. 'created_at:desc,name'.
+func ParseSortOption(value string) ([]SortKey, error) {
+	keys := []SortKey{}
+	for _, f := range strings.Split(value, ",") {
+		field, order, _ := strings.Cut(f, ":")
+		if field == "" {
+			return nil, fmt.Errorf("sort must not contain empty fields")
+		}
+
+		key := SortKey{Field: field}
+		switch order {
+		case "", "asc":
+		case "desc":
+			key.Desc = true
+		default:
+			return nil, fmt.Errorf("order of sort field '%s' must be either asc or desc", field)
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// SortDocuments sorts the documents by the given keys. The sort is stable.
+// Documents missing the field of a key are always placed at the end.
+func SortDocuments(docs []interface{}, keys []SortKey) {
+	sort.SliceStable(docs, func(i, j int) bool {
+		for _, k := range keys {
+			if c := compareField(docs[i], docs[j], k); c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+}
+
+// compareField compares the documents by a single sort key and returns -1, 0 or 1.
+func compareField(x, y interface{}, k SortKey) int {
+	a, aOk := fieldValue(x, k.Field)
+	b, bOk := fieldValue(y, k.Field)
+
+	// Push documents without the field to the end.
+	switch {
+	case !aOk && !bOk:
+		return 0
+	case !aOk:
+		return 1
+	case !bOk:
+		return -1
+	}
+
+	c := CompareValues(a, b)
+	if k.Desc {
+		return -c
+	}
+	return c
+}
+
+// SortValues sorts decoded JSON values ascending using CompareValues.
+func SortValues(values []interface{}) {
+	sort.SliceStable(values, func(i, j int) bool {
+		return CompareValues(values[i], values[j]) < 0
+	})
+}
+
+// fieldValue returns the value of the top-level field of the document.
+func fieldValue(doc interface{}, field string) (interface{}, bool) {
+	m, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	v, ok := m[field]
+	return v, ok
+}
+
+// CompareValues compares two decoded JSON values and returns -1, 0 or 1.
+// Numbers and strings are compared by value. Values of different types are
+// ordered by type: numbers, strings, booleans and everything else.
+func CompareValues(a, b interface{}) int {
+	ra, rb := typeRank(a), typeRank(b)
+	if ra != rb {
+		if ra < rb {
+			return -1
+		}
+		return 1
+	}
+
+	switch av := a.(type) {
+	case float64:
+		bv := b.(float64)
+		switch {
+		case av < bv:
+			return -1
+		case av > bv:
+			return 1
+		}
+		return 0
+	case string:
+		return strings.Compare(av, b.(string))
+	case bool:
+		bv := b.(bool)
+		switch {
+		case av == bv:
+			return 0
+		case !av:
+			return -1
+		}
+		return 1
+	}
+
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// typeRank returns the ordering rank of the type of a decoded JSON value.
+func typeRank(v interface{}) int {
+	switch v.(type) {
+	case float64:
+		return 0
+	case string:
+		return 1
+	case bool:
+		return 2
+	}
+	return 3
+}
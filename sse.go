@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"goji.io/pat"
+	"golang.org/x/net/context"
+)
+
+// sseHeartbeatInterval is the interval of heartbeat comments on event streams.
+// They keep proxies from closing idle connections.
+const sseHeartbeatInterval = 15 * time.Second
+
+// EventStreamHandler handles: GET /db/:collection/events.
+// Streams the change events of the collection as server-sent events, a lighter
+// alternative to WatchCollectionHandler. Every event is sent as a 'data:' line
+// containing the JSON encoded Event. The stream ends when the client disconnects
+// or the server shuts down.
+func (d *DBController) EventStreamHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	collName := pat.Param(ctx, "collection")
+
+	if coll := d.UseCollection(ctx, w); coll == nil {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		WriteError(ctx, w, http.StatusInternalServerError, CodeInternal, "streaming is not supported")
+		return
+	}
+
+	// The stream outlives the write timeout of the http server.
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		Logger(ctx).Warn("could not lift write deadline for event stream", "error", err)
+	}
+
+	events, cancel := d.Events.Subscribe(collName)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-d.Events.Done():
+			return
+		case e, ok := <-events:
+			if !ok {
+				Logger(ctx).Warn("event stream fell behind, closing it", "collection", collName)
+				return
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				Logger(ctx).Error("could not encode event", "error", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
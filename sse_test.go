@@ -0,0 +1,46 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"goji.io"
+	"goji.io/pat"
+	"golang.org/x/net/context"
+)
+
+func TestEventStreamEndsOnShutdown(t *testing.T) {
+	d := newTestController(t)
+	if err := d.DB.Create("books"); err != nil {
+		t.Fatal(err)
+	}
+
+	mux := goji.NewMux()
+	mux.HandleFuncC(pat.Get("/db/:collection/events"), d.EventStreamHandler)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	srv.Config.RegisterOnShutdown(d.Events.Close)
+
+	resp, err := http.Get(srv.URL + "/db/books/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Config.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+
+	// The stream must be finished, not cut off.
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		t.Errorf("reading stream error = %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"goji.io/pat"
+	"golang.org/x/net/context"
+)
+
+// Limits for the number of documents returned by TextSearchHandler.
+const (
+	textSearchDefaultLimit = 100
+	textSearchMaxLimit     = 1000
+)
+
+// TextSearchHandler handles: GET /db/:collection/search?q=term.
+// Returns the documents that contain the term as case-insensitive substring
+// in any string value, including values in nested objects and arrays.
+// Hidden fields of the collection are not searched unless ?raw=true is given.
+// This is a brute-force scan over all documents of the collection, so it gets
+// slow for large collections. Use indexes and POST /db/search/:collection there.
+// The result can be paged with 'limit' (default 100, at most 1000) and 'offset'
+// and is returned in the envelope of PageEnvelope.
+func (d *DBController) TextSearchHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	collName := pat.Param(ctx, "collection")
+
+	coll := d.UseCollection(ctx, w)
+	if coll == nil {
+		return
+	}
+
+	term := strings.ToLower(r.URL.Query().Get("q"))
+	if term == "" {
+		WriteError(ctx, w, http.StatusBadRequest, CodeBadRequest, "q must be given")
+		return
+	}
+
+	limit, offset, err := ParsePaging(r)
+	if err != nil {
+		WriteError(ctx, w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	if limit == 0 {
+		limit = textSearchDefaultLimit
+	}
+	if limit > textSearchMaxLimit {
+		limit = textSearchMaxLimit
+	}
+
+	hideDeleted := d.hideDeleted(collName, r)
+	hidden := d.hiddenPrefixes(collName, r)
+
+	// Only the ids are collected during the scan so memory stays small.
+	ids := []int{}
+	ForEachDocument(coll, func(id int, doc map[string]interface{}) bool {
+		if hideDeleted && IsDeleted(doc) {
+			return true
+		}
+		if MatchText(HideFields(doc, hidden, d.IDField), term) {
+			ids = append(ids, id)
+		}
+		return true
+	})
+	sort.Ints(ids)
+
+	total := len(ids)
+	results := []interface{}{}
+	for _, id := range PageInts(ids, limit, offset) {
+		doc, err := coll.Read(id)
+		if err != nil {
+			// The document was deleted after the scan.
+			continue
+		}
+		results = append(results, doc)
+	}
+
+	WriteResponse(ctx, w, http.StatusOK, PageEnvelope(d.ResponseDocuments(collName, r, results), total, limit, offset))
+}
+
+// MatchText reports whether any string in the decoded JSON value contains
+// the term. The term must be lower case.
+func MatchText(v interface{}, term string) bool {
+	switch v := v.(type) {
+	case string:
+		return strings.Contains(strings.ToLower(v), term)
+	case map[string]interface{}:
+		for _, e := range v {
+			if MatchText(e, term) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, e := range v {
+			if MatchText(e, term) {
+				return true
+			}
+		}
+	}
+	return false
+}
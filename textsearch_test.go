@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestTextSearchHiddenFields(t *testing.T) {
+	d := newTestController(t)
+	d.Collections["users"] = &CollectionConfig{Hidden: []string{"_"}}
+	if err := d.DB.Create("users"); err != nil {
+		t.Fatal(err)
+	}
+	createDocument(t, d, "users", `{"name": "alice", "_password": "secret"}`)
+	createDocument(t, d, "users", `{"name": "secret agent"}`)
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{path: "/db/users/search?q=secret", want: 1},
+		{path: "/db/users/search?q=secret&raw=true", want: 2},
+		{path: "/db/users/search?q=alice", want: 1},
+	}
+
+	for _, tt := range tests {
+		w := serve(t, d, "GET", tt.path, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, want %d", tt.path, w.Code, http.StatusOK)
+		}
+		if got := decode(t, w)["total"]; got != float64(tt.want) {
+			t.Errorf("%s: total = %v, want %d", tt.path, got, tt.want)
+		}
+	}
+}
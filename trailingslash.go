@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Modes for handling request paths with a trailing slash.
+const (
+	// TrailingSlashStrict serves paths as they are, so a trailing slash does not match any route.
+	TrailingSlashStrict = "strict"
+	// TrailingSlashStrip removes the trailing slash and serves the canonical path.
+	TrailingSlashStrip = "strip"
+	// TrailingSlashRedirect redirects to the canonical path without trailing slash.
+	TrailingSlashRedirect = "redirect"
+)
+
+// TrailingSlash wraps the router and handles paths like /db/books/ according to mode.
+// goji routes a request before running its middleware, so this has to wrap the
+// whole mux instead of being added with UseC.
+func TrailingSlash(mode string, h http.Handler) (http.Handler, error) {
+	switch mode {
+	case TrailingSlashStrict:
+		return h, nil
+	case TrailingSlashStrip, TrailingSlashRedirect:
+	default:
+		return nil, fmt.Errorf("unknown trailing slash mode '%s'", mode)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		if len(path) <= 1 || !strings.HasSuffix(path, "/") {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		u := *r.URL
+		u.Path = strings.TrimRight(path, "/")
+		u.RawPath = strings.TrimRight(u.RawPath, "/")
+		if u.Path == "" {
+			u.Path = "/"
+		}
+
+		if mode == TrailingSlashRedirect {
+			// 301 lets clients change the method to GET, 308 keeps method and body.
+			status := http.StatusPermanentRedirect
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				status = http.StatusMovedPermanently
+			}
+			http.Redirect(w, r, u.RequestURI(), status)
+			return
+		}
+
+		r2 := *r
+		r2.URL = &u
+		h.ServeHTTP(w, &r2)
+	}), nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrailingSlash(t *testing.T) {
+	tests := []struct {
+		mode         string
+		method       string
+		path         string
+		want         int
+		wantLocation string
+	}{
+		{mode: TrailingSlashStrict, method: "GET", path: "/db/books/", want: http.StatusNotFound},
+		{mode: TrailingSlashStrict, method: "GET", path: "/db/books", want: http.StatusOK},
+		{mode: TrailingSlashStrip, method: "GET", path: "/db/books/?limit=1", want: http.StatusOK},
+		{mode: TrailingSlashStrip, method: "POST", path: "/db/books//", want: http.StatusCreated},
+		{mode: TrailingSlashRedirect, method: "GET", path: "/db/books/?limit=1", want: http.StatusMovedPermanently, wantLocation: "/db/books?limit=1"},
+		{mode: TrailingSlashRedirect, method: "POST", path: "/db/books/", want: http.StatusPermanentRedirect, wantLocation: "/db/books"},
+		{mode: TrailingSlashRedirect, method: "GET", path: "/health", want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode+" "+tt.method+" "+tt.path, func(t *testing.T) {
+			d := newTestController(t)
+			if err := d.DB.Create("books"); err != nil {
+				t.Fatal(err)
+			}
+			h, err := TrailingSlash(tt.mode, newTestMux(d))
+			if err != nil {
+				t.Fatalf("TrailingSlash() error = %v", err)
+			}
+
+			body := ""
+			if tt.method == "POST" {
+				body = `{"title": "Dune"}`
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, newRequest(tt.method, tt.path, body))
+
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+			if got := w.Header().Get("Location"); tt.wantLocation != "" && got != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", got, tt.wantLocation)
+			}
+		})
+	}
+
+	if _, err := TrailingSlash("ignore", http.NotFoundHandler()); err == nil {
+		t.Error("TrailingSlash(ignore) error = nil, want error")
+	}
+}
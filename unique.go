@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/HouzuoGuo/tiedot/db"
+	"golang.org/x/net/context"
+)
+
+// UniqueError is returned when a document violates a uniqueness constraint of its collection.
+type UniqueError struct {
+	Field string
+}
+
+func (e *UniqueError) Error() string {
+	return "value of field " + e.Field + " is already used by another document"
+}
+
+// ErrDocumentExists is returned by conditional creates if a document with the
+// same natural key already exists.
+var ErrDocumentExists = errors.New("a document with the same key already exists")
+
+// LockUnique locks the collection for a check-and-write cycle if it has unique fields
+// or a natural key, so concurrent writes cannot both pass CheckUnique or CheckKeyAbsent
+// with the same value. It returns the function to unlock the collection again.
+func (d *DBController) LockUnique(collName string) (unlock func()) {
+	cfg := d.CollectionConfig(collName)
+	if len(cfg.Unique) == 0 && cfg.Key == nil {
+		return func() {}
+	}
+	return d.locks.LockCollection(collName)
+}
+
+// CheckUnique returns a *UniqueError if the value of a unique field of doc is
+// already used by another document of the collection. id is the id of doc
+// or -1 for new documents. Documents without the field are not checked.
+// Indexed fields are checked with an index lookup, all others need a scan.
+func (d *DBController) CheckUnique(collName string, coll *db.Col, id int, doc map[string]interface{}) error {
+	for _, path := range d.CollectionConfig(collName).Unique {
+		v, ok := pathValue(doc, path)
+		if !ok || v == nil {
+			continue
+		}
+
+		conflict, err := valueUsed(coll, path, v, id)
+		if err != nil {
+			return err
+		}
+		if conflict {
+			return &UniqueError{Field: strings.Join(path, ".")}
+		}
+	}
+	return nil
+}
+
+// CheckKeyAbsent returns ErrDocumentExists if another document of the collection
+// has the same value in the natural key field as doc.
+// The collection must have a key and doc must contain a value for it.
+func (d *DBController) CheckKeyAbsent(collName string, coll *db.Col, doc map[string]interface{}) error {
+	path := d.CollectionConfig(collName).Key
+	v, _ := pathValue(doc, path)
+
+	used, err := valueUsed(coll, path, v, -1)
+	if err != nil {
+		return err
+	}
+	if used {
+		return ErrDocumentExists
+	}
+	return nil
+}
+
+// valueUsed reports whether a document other than the one with the given id has
+// the value at path. Indexed paths are checked with an index lookup, all others need a scan.
+func valueUsed(coll *db.Col, path []string, v interface{}, id int) (bool, error) {
+	if coll == nil {
+		// A collection that does not exist yet has no documents.
+		return false, nil
+	}
+	f := Filter{Path: path, Value: fmt.Sprint(v)}
+	used := false
+
+	if HasIndex(coll, path) {
+		ids, err := QueryIDs(coll, f.Query())
+		if err != nil {
+			return false, err
+		}
+		for _, other := range ids {
+			if other == id {
+				continue
+			}
+			// Index lookups work on hashes, so compare the actual values.
+			if existing, err := coll.Read(other); err == nil && f.Match(existing) {
+				return true, nil
+			}
+		}
+	} else {
+		ForEachDocument(coll, func(other int, existing map[string]interface{}) bool {
+			used = other != id && f.Match(existing)
+			return !used
+		})
+	}
+
+	return used, nil
+}
+
+// WriteInsertError writes the error response for a document that could not be stored.
+// Uniqueness violations are answered with 409, failed conditional creates with 412,
+// an exceeded collection limit with 403 and all other errors with 500.
+func WriteInsertError(ctx context.Context, w http.ResponseWriter, err error) {
+	var uniqueErr *UniqueError
+	if errors.As(err, &uniqueErr) {
+		WriteError(ctx, w, http.StatusConflict, CodeConflict, uniqueErr.Error())
+		return
+	}
+	if err == ErrDocumentExists {
+		WriteError(ctx, w, http.StatusPreconditionFailed, CodePreconditionFailed, err.Error())
+		return
+	}
+	if err == ErrCollectionLimit {
+		WriteCollectionLimitError(ctx, w)
+		return
+	}
+	WriteError(ctx, w, http.StatusInternalServerError, CodeInternal, "could not store document: "+err.Error())
+}
+
+// InsertError returns the per-item error of bulk operations for a document that could not be stored.
+func InsertError(err error) map[string]interface{} {
+	var uniqueErr *UniqueError
+	if errors.As(err, &uniqueErr) {
+		return NewError(CodeConflict, uniqueErr.Error())
+	}
+	if err == ErrCollectionLimit {
+		return NewError(CodeCollectionLimit, err.Error())
+	}
+	return NewError(CodeInternal, "could not store document: "+err.Error())
+}
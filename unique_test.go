@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestUniqueFields(t *testing.T) {
+	for _, indexed := range []bool{false, true} {
+		name := "scan"
+		if indexed {
+			name = "index"
+		}
+
+		t.Run(name, func(t *testing.T) {
+			d := newTestController(t)
+			if err := d.SetupCollections(writeConfig(t, "users unique=email,profile.nick\n"), SetupOptions{}); err != nil {
+				t.Fatalf("SetupCollections() error = %v", err)
+			}
+			if indexed {
+				if err := d.DB.Use("users").Index([]string{"email"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			id := createDocument(t, d, "users", `{"email": "a@example.com", "profile": {"nick": "al"}}`)
+
+			tests := []struct {
+				method string
+				path   string
+				body   string
+				want   int
+			}{
+				{method: "POST", path: "/db/users", body: `{"email": "a@example.com"}`, want: http.StatusConflict},
+				{method: "POST", path: "/db/users", body: `{"email": "b@example.com", "profile": {"nick": "al"}}`, want: http.StatusConflict},
+				{method: "POST", path: "/db/users", body: `{"name": "no email"}`, want: http.StatusCreated},
+				{method: "PUT", path: "/db/users/" + id, body: `{"email": "a@example.com", "profile": {"nick": "al2"}}`, want: http.StatusOK},
+				{method: "PATCH", path: "/db/users/" + id, body: `{"email": "c@example.com"}`, want: http.StatusOK},
+				{method: "POST", path: "/db/users", body: `{"email": "a@example.com"}`, want: http.StatusCreated},
+				{method: "PUT", path: "/db/users/4711", body: `{"email": "c@example.com"}`, want: http.StatusConflict},
+			}
+
+			for _, tt := range tests {
+				if w := serve(t, d, tt.method, tt.path, tt.body); w.Code != tt.want {
+					t.Errorf("%s %s %s: status = %d, want %d", tt.method, tt.path, tt.body, w.Code, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestUniqueFieldsConcurrent(t *testing.T) {
+	const workers = 10
+
+	d := newTestController(t)
+	if err := d.SetupCollections(writeConfig(t, "users unique=email\n"), SetupOptions{}); err != nil {
+		t.Fatalf("SetupCollections() error = %v", err)
+	}
+
+	var wg sync.WaitGroup
+	codes := make([]int, workers)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = serve(t, d, "POST", "/db/users", `{"email": "a@example.com"}`).Code
+		}(i)
+	}
+	wg.Wait()
+
+	created := 0
+	for _, code := range codes {
+		switch code {
+		case http.StatusCreated:
+			created++
+		case http.StatusConflict:
+		default:
+			t.Errorf("status = %d, want %d or %d", code, http.StatusCreated, http.StatusConflict)
+		}
+	}
+	if created != 1 {
+		t.Errorf("%d creates succeeded, want 1", created)
+	}
+}
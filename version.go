@@ -0,0 +1,28 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+
+	"golang.org/x/net/context"
+)
+
+// Build information, injected at build time with e.g.
+//
+//	go build -ldflags "-X main.Version=1.2.0 -X main.Commit=$(git rev-parse HEAD) -X main.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildTime = "unknown"
+)
+
+// VersionHandler handles: GET /version.
+// Returns the build information of the running server.
+func VersionHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	WriteResponse(ctx, w, http.StatusOK, map[string]interface{}{
+		"version":   Version,
+		"commit":    Commit,
+		"buildTime": BuildTime,
+		"go":        runtime.Version(),
+	})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"goji.io/pat"
+	"golang.org/x/net/context"
+	"golang.org/x/net/websocket"
+)
+
+// WatchCollectionHandler handles: GET /db/:collection/watch.
+// Upgrades the connection to a WebSocket and pushes a JSON encoded Event for
+// every document created, updated or deleted in the collection through this
+// server. Changes made by other processes directly in the database are not seen.
+// The connection is closed if the client does not keep up with the events
+// and when the server shuts down.
+func (d *DBController) WatchCollectionHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	collName := pat.Param(ctx, "collection")
+
+	if coll := d.UseCollection(ctx, w); coll == nil {
+		return
+	}
+
+	websocket.Server{
+		Handshake: checkWatchOrigin,
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+
+			// The connection outlives the write timeout of the http server.
+			ws.SetDeadline(time.Time{})
+
+			events, cancel := d.Events.Subscribe(collName)
+			defer cancel()
+
+			Logger(ctx).Info("watching collection", "collection", collName)
+
+			// Clients do not send anything, reading only detects closed connections.
+			closed := make(chan struct{})
+			go func() {
+				io.Copy(io.Discard, ws)
+				close(closed)
+			}()
+
+			for {
+				select {
+				case e, ok := <-events:
+					if !ok {
+						Logger(ctx).Warn("watcher fell behind, closing connection", "collection", collName)
+						return
+					}
+					if err := websocket.JSON.Send(ws, e); err != nil {
+						return
+					}
+				case <-closed:
+					return
+				case <-d.Events.Done():
+					return
+				}
+			}
+		},
+	}.ServeHTTP(w, r)
+}
+
+// checkWatchOrigin accepts clients without Origin header and browsers on the same host,
+// so other websites cannot watch collections through a visitor's browser.
+func checkWatchOrigin(config *websocket.Config, r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || u.Host != r.Host {
+		return fmt.Errorf("origin %s is not allowed", origin)
+	}
+	return nil
+}